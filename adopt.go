@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// adopt makes an existing resource definition a volume of this plugin, its data is left as it is. The file system
+// has to be known, either from LINSTOR or given via fs=.
+func (l *LinstorDriver) adopt(ctx context.Context, c *client.Client, req *volume.CreateRequest, params *LinstorParams, resdef client.ResourceDefinition) error {
+	if resdef.Props[pluginFlagKey] == pluginFlagValue {
+		debugf("Volume '%s' is already managed by this plugin, nothing to adopt", req.Name)
+		return nil
+	}
+	err := l.retry(ctx, c, true, func(c *client.Client) error {
+		_, err := getVolumeDefinition(ctx, c, req.Name, int(params.VolumeNumber))
+		return err
+	})
+	if err == client.NotFoundError {
+		return fmt.Errorf("Resource definition '%s' has no volume %d to adopt", req.Name, params.VolumeNumber)
+	} else if err != nil {
+		return timeoutError("get volume definition", err)
+	}
+
+	props := map[string]string{pluginFlagKey: pluginFlagValue}
+	known := resdef.Props[pluginFSTypeKey]
+	if _, ok := req.Options["fs"]; ok {
+		if known != "" && known != params.FS {
+			return fmt.Errorf("Resource definition '%s' has file system '%s', not '%s'", req.Name, known, params.FS)
+		}
+		if params.FS == rawFS {
+			props[pluginFSKey] = rawFS
+		} else {
+			props[pluginFSTypeKey] = params.FS
+		}
+	} else if known == "" {
+		return fmt.Errorf("Resource definition '%s' has no file system LINSTOR knows of, adopting it needs fs=<type> or fs=none", req.Name)
+	}
+	// existing data lives in the root of the file system, not in a directory the plugin would have created
+	props[pluginSubdirKey] = ""
+	if _, ok := req.Options["subdir"]; ok {
+		props[pluginSubdirKey] = params.Subdir
+	}
+	for key, val := range optionProps(req.Options, params) {
+		props[key] = val
+	}
+
+	infof("Adopting resource definition '%s' as volume", req.Name)
+	err = l.retry(ctx, c, true, func(c *client.Client) error {
+		return c.ResourceDefinitions.Modify(ctx, req.Name, client.GenericPropsModify{OverrideProps: props})
+	})
+	if err != nil {
+		return timeoutError("modify resource definition", err)
+	}
+	if params.SnapshotSchedule != "" {
+		l.scheduleSnapshots(req.Name, params.SnapshotSchedule, params.SnapshotKeep)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// clientCache keeps the last client, so operations share its connections instead of setting up a new one each time
+type clientCache struct {
+	mu     sync.Mutex
+	key    string
+	client *client.Client
+}
+
+// get returns the cached client if it was made for key
+func (c *clientCache) get(key string) *client.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key != key {
+		return nil
+	}
+	return c.client
+}
+
+func (c *clientCache) set(key string, cl *client.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.client = key, cl
+}
+
+// reset drops the cached client, the next one fails over to another controller if need be
+func (c *clientCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.client = "", nil
+}
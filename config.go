@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/ini.v1"
+)
+
+// configCache keeps the last config read, along with the stamp of what it was read from
+type configCache struct {
+	mu     sync.Mutex
+	stamp  string
+	config *LinstorConfig
+}
+
+// configStamp changes whenever the config file, a file in the config directory or an LS_* variable changes
+func (l *LinstorDriver) configStamp() string {
+	paths := []string{l.config}
+	if l.configDir != "" {
+		entries, _ := ioutil.ReadDir(l.configDir)
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(l.configDir, entry.Name()))
+		}
+	}
+	var stamps []string
+	for _, path := range paths {
+		// files of Kubernetes secrets are links, which are replaced on updates
+		if info, err := os.Stat(path); err == nil {
+			stamps = append(stamps, fmt.Sprintf("%s %s %d", path, info.ModTime(), info.Size()))
+		}
+	}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "LS_") {
+			stamps = append(stamps, env)
+		}
+	}
+	return strings.Join(stamps, "\n")
+}
+
+// configKeys are the keys known in the [global] section, as go-ini maps them in insensitive mode
+var configKeys = iniKeys(LinstorConfig{}, LinstorParams{})
+
+func iniKeys(structs ...interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	for _, s := range structs {
+		t := reflect.TypeOf(s)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("ini"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			keys[strings.ToLower(name)] = true
+		}
+	}
+	return keys
+}
+
+// warnUnknownKeys logs keys of source that do not map to any setting, once per key
+func (l *LinstorDriver) warnUnknownKeys(keys []string, source string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if configKeys[key] || l.unknownKeys[key] {
+			continue
+		}
+		l.unknownKeys[key] = true
+		warnf("Ignoring unknown key '%s' in section [global] of '%s'", key, source)
+	}
+}
+
+// loadConfigDir maps the files in LS_CONFIG_DIR onto result the way a [global] section would be, e.g. a file
+// 'password' sets the password. This is how Docker and Kubernetes mount secrets, so credentials do not have to be
+// in the config file.
+func (l *LinstorDriver) loadConfigDir(result interface{}) error {
+	if l.configDir == "" {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(l.configDir)
+	if err != nil {
+		return fmt.Errorf("Could not read config directory '%s': %w", l.configDir, err)
+	}
+	file, err := ini.InsensitiveLoad([]byte{})
+	if err != nil {
+		return err
+	}
+	section := file.Section("global")
+	for _, entry := range entries {
+		// Kubernetes keeps the actual files in hidden directories and links them
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(l.configDir, entry.Name())
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Could not read config '%s': %w", path, err)
+		}
+		if _, err := section.NewKey(entry.Name(), strings.TrimSpace(string(content))); err != nil {
+			return fmt.Errorf("Could not read config '%s': %w", path, err)
+		}
+	}
+	l.warnUnknownKeys(section.KeyStrings(), l.configDir)
+	if err := section.MapTo(result); err != nil {
+		return fmt.Errorf("Could not map config directory '%s': %w", l.configDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewConfigCached(t *testing.T) {
+	d := newTestDriver(t, nil, "storage-pool = first")
+	config, err := d.newConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StoragePool != "first" {
+		t.Fatalf("expected storage pool 'first', got '%s'", config.StoragePool)
+	}
+	// copies, a caller changing its config does not change the cached one
+	config.StoragePool = "changed"
+	if config, _ = d.newConfig(); config.StoragePool != "first" {
+		t.Errorf("expected the cached storage pool 'first', got '%s'", config.StoragePool)
+	}
+
+	if err := ioutil.WriteFile(d.config, []byte("[global]\nstorage-pool = second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(d.config, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ = d.newConfig(); config.StoragePool != "second" {
+		t.Errorf("expected the changed config to be read again, got storage pool '%s'", config.StoragePool)
+	}
+}
+
+func TestNewConfigDefaultPorts(t *testing.T) {
+	config, err := newTestDriver(t, nil).newConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DefaultHTTPPort != defaultHTTPPort || config.DefaultHTTPSPort != defaultHTTPSPort {
+		t.Errorf("expected the ports %d and %d, got %d and %d", defaultHTTPPort, defaultHTTPSPort, config.DefaultHTTPPort, config.DefaultHTTPSPort)
+	}
+
+	d := newTestDriver(t, nil, "controllers = ctrl-a, linstor+ssl://ctrl-b, ctrl-c:3000", "default-http-port = 8080", "default-https-port = 8443")
+	if config, err = d.newConfig(); err != nil {
+		t.Fatal(err)
+	}
+	urls, err := d.newBaseURLs(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range urls {
+		got = append(got, u.String())
+	}
+	expected := []string{"http://ctrl-a:8080", "https://ctrl-b:8443", "http://ctrl-c:3000"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for _, line := range []string{"default-http-port = 70000", "default-https-port = -1"} {
+		if _, err := newTestDriver(t, nil, line).newConfig(); err == nil {
+			t.Errorf("expected '%s' to be rejected", line)
+		}
+	}
+}
+
+func TestReplicasOnFromConfig(t *testing.T) {
+	d := newTestDriver(t, nil, "replicas-on-different = Aux/rack  Aux/room", "replicas-on-same = Aux/site")
+
+	params, err := d.newParams("vol", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"Aux/rack", "Aux/room"}; !reflect.DeepEqual(params.ReplicasOnDifferent, expected) {
+		t.Errorf("expected replicas-on-different %v from the config, got %v", expected, params.ReplicasOnDifferent)
+	}
+	if expected := []string{"Aux/site"}; !reflect.DeepEqual(params.ReplicasOnSame, expected) {
+		t.Errorf("expected replicas-on-same %v from the config, got %v", expected, params.ReplicasOnSame)
+	}
+
+	// an option replaces the list from the config, the other one is kept
+	params, err = d.newParams("vol", map[string]string{"replicas-on-different": "Aux/zone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"Aux/zone"}; !reflect.DeepEqual(params.ReplicasOnDifferent, expected) {
+		t.Errorf("expected replicas-on-different %v from the option, got %v", expected, params.ReplicasOnDifferent)
+	}
+	if expected := []string{"Aux/site"}; !reflect.DeepEqual(params.ReplicasOnSame, expected) {
+		t.Errorf("expected replicas-on-same %v from the config, got %v", expected, params.ReplicasOnSame)
+	}
+
+	// an empty option drops the constraint for this volume
+	params, err = d.newParams("vol", map[string]string{"replicas-on-same": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params.ReplicasOnSame) != 0 {
+		t.Errorf("expected no replicas-on-same, got %v", params.ReplicasOnSame)
+	}
+}
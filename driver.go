@@ -2,21 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	linstor "github.com/LINBIT/golinstor"
 	"github.com/LINBIT/golinstor/client"
-	"github.com/docker/go-connections/tlsconfig"
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/mitchellh/mapstructure"
-	"github.com/rck/unit"
 	"github.com/vrischmann/envconfig"
 	"gopkg.in/ini.v1"
 	"k8s.io/kubernetes/pkg/util/mount"
@@ -25,35 +32,153 @@ import (
 )
 
 const (
-	datadir         = "data"
-	pluginFlagKey   = "Aux/is-linstor-docker-volume"
-	pluginFlagValue = "true"
-	pluginFSTypeKey = "FileSystem/Type"
+	datadir                = "data"
+	pluginFlagKey          = "Aux/is-linstor-docker-volume"
+	pluginFlagValue        = "true"
+	pluginFSTypeKey        = "FileSystem/Type"
+	pluginMkfsParamsKey    = "FileSystem/MkfsParams"
+	pluginOptionPrefix     = "Aux/linstor-docker-volume/"
+	pluginSubdirKey        = pluginOptionPrefix + "subdir"
+	pluginFSKey            = pluginOptionPrefix + "fs"
+	pluginConfirmRemoveKey = pluginOptionPrefix + "confirm-remove"
+	defaultRequestTimeout  = 60 * time.Second
+	controllerProbeTimeout = 5 * time.Second
+	devicePollInterval     = 500 * time.Millisecond
+	readyPollInterval      = 2 * time.Second
+	promotablePollMax      = 8 * time.Second
+	defaultDeviceTimeout   = 30 * time.Second
+	defaultHTTPPort        = 3370
+	defaultHTTPSPort       = 3371
+	// defaultPeerSlots is what LINSTOR reserves DRBD metadata for unless peer-slots says otherwise
+	defaultPeerSlots = 7
+	// grossSizeFlag makes LINSTOR take the size of a volume definition including the DRBD metadata
+	grossSizeFlag = "GROSS_SIZE"
+	// drbdHandlerOptions is where LINSTOR takes the handlers section of drbd.conf from
+	drbdHandlerOptions = linstor.NamespcDrbdOptions + "/Handlers"
+	// rawFS volumes have no file system, the block device itself is handed to containers
+	rawFS = "none"
 )
 
 type LinstorConfig struct {
 	Controllers string
 	Username    string
 	Password    string
-	CertFile    string
-	KeyFile     string
-	CAFile      string
+	// ports of controllers given without one
+	DefaultHTTPPort  int `ini:"default-http-port"`
+	DefaultHTTPSPort int `ini:"default-https-port"`
+
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// certificate, key and CA as PEM, they take precedence over the files
+	CertPem string `ini:"cert-pem"`
+	KeyPem  string `ini:"key-pem"`
+	CAPem   string `ini:"ca-pem"`
+	// TLSInsecure skips the verification of the controller certificate
+	TLSInsecure bool `ini:"tls-insecure"`
+
+	// RequestTimeout bounds each driver operation talking to LINSTOR
+	RequestTimeout time.Duration
+	// MaxRetries of calls failing with transient errors
+	MaxRetries int
+
+	// ForceRemove detaches volumes from all nodes before they are removed, RemoveTimeout bounds the whole removal
+	ForceRemove   bool          `ini:"force-remove"`
+	RemoveTimeout time.Duration `ini:"remove-timeout"`
+	// RemoveLocal only detaches volumes that are accessed diskless from this node and stored elsewhere
+	RemoveLocal bool `ini:"remove-local"`
+
+	// DeviceTimeout is how long Mount waits for the device node to show up
+	DeviceTimeout time.Duration `ini:"device-timeout"`
+	// WaitPrimary is how long Mount waits for the device to become writable, 0 does not wait
+	WaitPrimary time.Duration `ini:"wait-primary"`
+	// WaitPromotable is how long Mount waits for LINSTOR to report the volume as promotable, 0 does not wait
+	WaitPromotable time.Duration `ini:"wait-promotable"`
+	// WaitReady is how long Create waits for a replica to become UpToDate, 0 does not wait
+	WaitReady time.Duration `ini:"wait-ready"`
+	// MkfsTimeout bounds placing the resources of a new volume, which includes mkfs, 0 keeps the request timeout
+	MkfsTimeout time.Duration `ini:"mkfs-timeout"`
+
+	// defaults for volumes, config and options take precedence
+	StoragePool         string `ini:"storage-pool"`
+	DisklessStoragePool string `ini:"diskless-storage-pool"`
+	Replicas            int32  `ini:"replicas"`
+	// MinSize is the smallest volume size, StrictSize rejects smaller requests instead of enlarging them
+	MinSize    string `ini:"min-size"`
+	StrictSize bool   `ini:"strict-size"`
+
+	// DefaultFS is mounted for volumes that do not say which file system they have and where none is detected
+	DefaultFS string `ini:"default-fs"`
+
+	// ListLocalOnly restricts List to volumes with a resource on this node
+	ListLocalOnly bool `ini:"list-local-only"`
+	// EventWebhook receives a POST for every volume created, mounted, unmounted or removed
+	EventWebhook string `ini:"event-webhook"`
+
+	// LUKS master passphrase, either inline or read from a file. Never log these.
+	LuksPassphrase     string
+	LuksPassphraseFile string
+	// the passphrase rotate-passphrase replaces the master passphrase with
+	LuksNewPassphrase     string
+	LuksNewPassphraseFile string
 }
 
 type LinstorParams struct {
-	Nodes               []string `mapstructure:"nodes"`
-	ReplicasOnDifferent []string `mapstructure:"replicas-on-different"`
-	ReplicasOnSame      []string `mapstructure:"replicas-on-same"`
-	DisklessStoragePool string   `mapstructure:"diskless-storage-pool"`
-	DoNotPlaceWithRegex string   `mapstructure:"do-not-place-with-regex"`
-	FS                  string   `mapstructure:"fs"`
-	FSOpts              string   `mapstructure:"fsopts"`
-	MountOpts           []string `mapstructure:"mount-opts"`
-	StoragePool         string   `mapstructure:"storage-pool"`
-	Size                string   `mapstructure:"size"`
-	SizeKiB             uint64
-	Replicas            int32    `mapstructure:"replicas"`
-	DisklessOnRemaining bool     `mapstructure:"diskless-on-remaining"`
+	Nodes               []string           `mapstructure:"nodes"`
+	DisklessNodes       []string           `mapstructure:"diskless-nodes"`
+	ReplicasOnDifferent []string           `mapstructure:"replicas-on-different" ini:"replicas-on-different" delim:" "`
+	ReplicasOnSame      []string           `mapstructure:"replicas-on-same" ini:"replicas-on-same" delim:" "`
+	DisklessStoragePool string             `mapstructure:"diskless-storage-pool" ini:"diskless-storage-pool"`
+	DoNotPlaceWithRegex string             `mapstructure:"do-not-place-with-regex"`
+	ResourceGroup       string             `mapstructure:"resource-group"`
+	SnapshotOf          string             `mapstructure:"snapshot-of"`
+	RestoreFrom         string             `mapstructure:"restore-from"`
+	RestoreVolume       string             `mapstructure:"-" ini:"-"`
+	RestoreSnapshot     string             `mapstructure:"-" ini:"-"`
+	FS                  string             `mapstructure:"fs"`
+	FSOpts              string             `mapstructure:"fsopts"`
+	FSLabel             string             `mapstructure:"fs-label"`
+	MkfsForce           bool               `mapstructure:"mkfs-force"`
+	FSBlockSize         int                `mapstructure:"fs-blocksize"`
+	Quota               string             `mapstructure:"quota"`
+	QuotaKiB            uint64             `mapstructure:"-" ini:"-"`
+	MountOpts           []string           `mapstructure:"mount-opts"`
+	Propagation         string             `mapstructure:"propagation"`
+	IOScheduler         string             `mapstructure:"io-scheduler"`
+	Discard             bool               `mapstructure:"discard"`
+	StoragePool         string             `mapstructure:"storage-pool" ini:"storage-pool"`
+	StoragePoolAuto     string             `mapstructure:"storage-pool-auto"`
+	Size                string             `mapstructure:"size"`
+	SizeKiB             uint64             `mapstructure:"-" ini:"-"`
+	Replicas            int32              `mapstructure:"replicas"`
+	DisklessOnRemaining bool               `mapstructure:"diskless-on-remaining"`
+	BestEffortPlacement bool               `mapstructure:"best-effort-placement"`
+	Encryption          bool               `mapstructure:"encryption"`
+	NVMe                bool               `mapstructure:"nvme"`
+	ReadOnly            bool               `mapstructure:"readonly"`
+	Subdir              string             `mapstructure:"subdir"`
+	VolumeNumber        int32              `mapstructure:"volume-number"`
+	Volumes             []string           `mapstructure:"volumes"`
+	VolumesKiB          []uint64           `mapstructure:"-" ini:"-"`
+	TieBreaker          bool               `mapstructure:"tiebreaker"`
+	AutoResize          bool               `mapstructure:"auto-resize"`
+	Protect             bool               `mapstructure:"protect"`
+	KeepDiskless        bool               `mapstructure:"keep-diskless"`
+	Adopt               bool               `mapstructure:"adopt"`
+	RotatePassphrase    bool               `mapstructure:"rotate-passphrase"`
+	MigrateTo           []string           `mapstructure:"migrate-to"`
+	LayerList           []string           `mapstructure:"layer-list"`
+	Layers              []client.LayerType `mapstructure:"-" ini:"-"`
+	DryRun              bool               `mapstructure:"dry-run"`
+	PeerSlots           int32              `mapstructure:"peer-slots"`
+	GrossSize           bool               `mapstructure:"gross-size"`
+	Port                int32              `mapstructure:"port"`
+	Minor               int32              `mapstructure:"minor"`
+	SnapshotSchedule    string             `mapstructure:"snapshot-schedule"`
+	SnapshotKeep        int                `mapstructure:"snapshot-keep"`
+
+	// Props are set on the resource definition as given via prop.<key>=<value>
+	Props map[string]string `mapstructure:"-" ini:"-"`
 
 	// DRBD options from docker-volume.conf [global]
 	Protocol              string `mapstructure:"protocol"`
@@ -67,35 +192,295 @@ type LinstorParams struct {
 	HandlerSplitBrain     string `mapstructure:"handler-split-brain"`
 	HandlerPriOnInconDegr string `mapstructure:"handler-pri-on-incon-degr"`
 	PrimarySetOn          string `mapstructure:"primary-set-on"`
+	VerifyAlg             string `mapstructure:"verify-alg"`
+	CsumsAlg              string `mapstructure:"csums-alg"`
+	DataIntegrityAlg      string `mapstructure:"data-integrity-alg"`
+	Quorum                string `mapstructure:"quorum"`
+	OnNoQuorum            string `mapstructure:"on-no-quorum"`
+	ResyncAfter           string `mapstructure:"resync-after"`
+	Transport             string `mapstructure:"transport"`
+}
+
+// drbdProps returns the DRBD options as the properties LINSTOR reads them from, it only applies each option in the
+// section of drbd.conf it belongs to. resyncAfter is the "<resource>/<volume>" of the resync-after option.
+func drbdProps(params *LinstorParams, resyncAfter string) map[string]string {
+	props := make(map[string]string)
+	for _, opt := range []struct{ namespace, key, val string }{
+		{linstor.NamespcDrbdNetOptions, "protocol", params.Protocol},
+		{linstor.NamespcDrbdNetOptions, "connect-int", params.ConnectInterval},
+		{linstor.NamespcDrbdNetOptions, "ping-int", params.PingInterval},
+		{linstor.NamespcDrbdNetOptions, "ping-timeout", params.PingTimeout},
+		{linstor.NamespcDrbdNetOptions, "max-buffers", params.MaxBuffers},
+		{linstor.NamespcDrbdNetOptions, "max-epoch-size", params.MaxEpochSize},
+		{linstor.NamespcDrbdNetOptions, "verify-alg", params.VerifyAlg},
+		{linstor.NamespcDrbdNetOptions, "csums-alg", params.CsumsAlg},
+		{linstor.NamespcDrbdNetOptions, "data-integrity-alg", params.DataIntegrityAlg},
+		{linstor.NamespcDrbdPeerDeviceOptions, "resync-rate", params.ResyncRate},
+		{linstor.NamespcDrbdDiskOptions, "al-extents", params.ALExtents},
+		{linstor.NamespcDrbdDiskOptions, "resync-after", resyncAfter},
+		{linstor.NamespcDrbdResourceOptions, "quorum", params.Quorum},
+		{linstor.NamespcDrbdResourceOptions, "on-no-quorum", params.OnNoQuorum},
+		{drbdHandlerOptions, "split-brain", params.HandlerSplitBrain},
+		{drbdHandlerOptions, "pri-on-incon-degr", params.HandlerPriOnInconDegr},
+	} {
+		if opt.val != "" {
+			props[opt.namespace+"/"+opt.key] = opt.val
+		}
+	}
+	return props
+}
+
+// drbdTransports maps the transport option to the transport types of LINSTOR
+var drbdTransports = map[string]string{"tcp": "IP", "rdma": "RDMA"}
+
+// knownLayers maps the layer-list option to LINSTOR layer types
+var knownLayers = map[string]client.LayerType{
+	"drbd":    client.DRBD,
+	"luks":    client.LUKS,
+	"storage": client.STORAGE,
+	"nvme":    client.NVME,
+}
+
+// isNVMeOnly reports whether a layer stack exports the volume via NVMe-oF without DRBD replication
+func isNVMeOnly(layers []client.LayerType) bool {
+	return hasLayer(layers, client.NVME) && !hasLayer(layers, client.DRBD)
+}
+
+func hasLayer(layers []client.LayerType, layer client.LayerType) bool {
+	for _, l := range layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// mountOptions are create options Mount needs as well, they are persisted as resource definition props
+var mountOptions = []string{"readonly", "mount-opts", "propagation", "volume-number", "tiebreaker", "auto-resize", "diskless-storage-pool", "quota", "io-scheduler", "discard"}
+
+// scheduleOptions are persisted as well, StartSnapshotSchedules picks them up again
+var scheduleOptions = []string{"snapshot-schedule", "snapshot-keep"}
+
+// removeOptions are persisted for Remove and Unmount
+var removeOptions = []string{"protect", "diskless-nodes", "keep-diskless"}
+
+// persistedOptions returns the create options stored in the props of a resource definition
+func persistedOptions(props map[string]string) map[string]string {
+	options := make(map[string]string)
+	for _, key := range mountOptions {
+		if val, ok := props[pluginOptionPrefix+key]; ok {
+			options[key] = val
+		}
+	}
+	// options are validated against the file system of the volume
+	if fs, ok := props[pluginFSTypeKey]; ok {
+		options["fs"] = fs
+	} else if fs, ok := props[pluginFSKey]; ok {
+		options["fs"] = fs
+	}
+	return options
+}
+
+// optionProps returns the props that persist the mount, schedule and remove options given in options
+func optionProps(options map[string]string, params *LinstorParams) map[string]string {
+	props := make(map[string]string)
+	for _, keys := range [][]string{mountOptions, scheduleOptions, removeOptions} {
+		for _, key := range keys {
+			if val, ok := options[key]; ok {
+				props[pluginOptionPrefix+key] = val
+			}
+		}
+	}
+	// Remove and Unmount compare these to "true" without decoding them, so yes or on have to be stored as such
+	for key, val := range map[string]bool{"protect": params.Protect, "keep-diskless": params.KeepDiskless} {
+		if _, ok := options[key]; ok {
+			props[pluginOptionPrefix+key] = strconv.FormatBool(val)
+		}
+	}
+	return props
+}
+
+// propOptionPrefix marks options that are passed through as resource definition props, auxOptionPrefix ones
+// that end up in the Aux namespace
+const (
+	propOptionPrefix = "prop."
+	auxOptionPrefix  = "aux."
+)
+
+// isReservedProp reports whether key is one of the props the plugin relies on, LINSTOR keys are case insensitive
+func isReservedProp(key string) bool {
+	for _, reserved := range []string{pluginFlagKey, pluginFSTypeKey} {
+		if strings.EqualFold(key, reserved) {
+			return true
+		}
+	}
+	return strings.HasPrefix(strings.ToLower(key), strings.ToLower(pluginOptionPrefix))
+}
+
+// errNoSuchVolume has the text of Docker's own error, so unknown volumes look the same for every driver
+var errNoSuchVolume = errors.New("no such volume")
+
+// supportedFS are the file systems LINSTOR creates via FileSystem/Type
+var supportedFS = map[string]bool{
+	"ext4": true,
+	"xfs":  true,
+}
+
+// propagationModes are the mount propagation flags mount(8) accepts as options
+var propagationModes = map[string]bool{
+	"shared":      true,
+	"rshared":     true,
+	"slave":       true,
+	"rslave":      true,
+	"private":     true,
+	"rprivate":    true,
+	"unbindable":  true,
+	"runbindable": true,
+}
+
+// mkfsForceFlags make mkfs of the supported file systems overwrite existing signatures
+var mkfsForceFlags = map[string]string{
+	"ext4": "-F",
+	"xfs":  "-f",
+}
+
+// blockSizes the supported file systems can be created with, ext4 can not be mounted with blocks larger than a page
+var blockSizes = map[string][]int{
+	"ext4": {1024, 2048, 4096},
+	"xfs":  {512, 1024, 2048, 4096, 8192, 16384, 32768, 65536},
+}
+
+// blockSizeFlags set the block size in mkfs of the supported file systems
+var blockSizeFlags = map[string]string{
+	"ext4": "-b %d",
+	"xfs":  "-b size=%d",
+}
+
+// maxLabelLength of the supported file systems
+var maxLabelLength = map[string]int{
+	"ext4": 16,
+	"xfs":  12,
 }
 
 type LinstorDriver struct {
-	config  string
-	node    string
-	root    string
-	mounter *mount.SafeFormatAndMount
-	resizer *mountutils.ResizeFs
+	config string
+	// configDir holds one file per key of [global], it is read after config
+	configDir string
+	node      string
+	root      string
+	mounter   *mount.SafeFormatAndMount
+	resizer   *mountutils.ResizeFs
+
+	mu          sync.Mutex
+	volumes     map[string]*volumeState
+	unknownKeys map[string]bool
+	configs     configCache
+	tls         tlsCache
+	clients     clientCache
+	jobs        snapshotJobs
+	mounts      mountRecords
+
+	// ctx is canceled by Shutdown, all LINSTOR calls derive from it
+	ctx     context.Context
+	stop    context.CancelFunc
+	ops     sync.WaitGroup
+	closing bool
+}
+
+// volumeState serializes Mount/Unmount of a volume and counts its active mounts
+type volumeState struct {
+	sync.Mutex
+	// users holding or waiting for the lock, guarded by the mutex of the driver
+	users  int
+	mounts int
+	subdir string
 }
 
-func NewLinstorDriver(config, node, root string) *LinstorDriver {
+func NewLinstorDriver(config, configDir, node, root string) *LinstorDriver {
+	ctx, stop := context.WithCancel(context.Background())
 	return &LinstorDriver{
-		config: config,
-		node:   node,
-		root:   root,
+		config:    config,
+		configDir: configDir,
+		node:      node,
+		root:      root,
 		mounter: &mount.SafeFormatAndMount{
 			Interface: mount.New("/bin/mount"),
 			Exec:      mount.NewOsExec(),
 		},
-		resizer: mountutils.NewResizeFs(exec.New()),
+		resizer:     mountutils.NewResizeFs(exec.New()),
+		volumes:     make(map[string]*volumeState),
+		unknownKeys: make(map[string]bool),
+		ctx:         ctx,
+		stop:        stop,
+	}
+}
+
+// lockVolume locks the state of volume name, the caller has to unlock it
+func (l *LinstorDriver) lockVolume(name string) *volumeState {
+	l.mu.Lock()
+	state, ok := l.volumes[name]
+	if !ok {
+		state = new(volumeState)
+		l.volumes[name] = state
+	}
+	state.users++
+	l.mu.Unlock()
+
+	state.Lock()
+	return state
+}
+
+// unlockVolume unlocks the state of volume name, it is dropped if the volume is not mounted and nobody waits for it
+func (l *LinstorDriver) unlockVolume(name string, state *volumeState) {
+	l.mu.Lock()
+	state.users--
+	if state.users == 0 && state.mounts == 0 {
+		delete(l.volumes, name)
+	}
+	l.mu.Unlock()
+
+	state.Unlock()
+}
+
+// newBaseURLs parses the comma separated controllers of config in the order they should be tried
+func (l *LinstorDriver) newBaseURLs(config *LinstorConfig) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, h := range strings.Split(config.Controllers, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		u, err := l.newBaseURL(h, config.DefaultHTTPPort, config.DefaultHTTPSPort)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
 	}
+	if len(urls) == 0 {
+		u, err := l.newBaseURL("", config.DefaultHTTPPort, config.DefaultHTTPSPort)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
 }
 
-func (l *LinstorDriver) newBaseURL(hosts string) (*url.URL, error) {
+func (l *LinstorDriver) newBaseURL(h string, httpPort, httpsPort int) (*url.URL, error) {
+	// a local socket has neither port nor TLS
+	if strings.HasPrefix(h, "unix://") {
+		u, err := url.Parse(h)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("Controller '%s' is missing the socket path", h)
+		}
+		return u, nil
+	}
 	scheme := "http"
-	host := "localhost:3370"
-	if hosts != "" {
-		parts := strings.SplitN(hosts, ",", 2)
-		h := parts[0]
+	host := "localhost"
+	if h != "" {
+		host = h
 		if p := strings.SplitN(h, "://", 2); len(p) == 2 {
 			if p[0] == "linstor+ssl" || p[0] == "https" {
 				scheme = "https"
@@ -103,263 +488,1990 @@ func (l *LinstorDriver) newBaseURL(hosts string) (*url.URL, error) {
 			host = p[1]
 		}
 	}
-
-	if !strings.Contains(host, ":") {
-		switch scheme {
-		case "http": host += ":3370"
-		case "https": host += ":3371"
+
+	// only add the default port if there is none, IPv6 literals may come with or without brackets
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := httpPort
+		if scheme == "https" {
+			port = httpsPort
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+	}
+	return url.Parse(scheme + "://" + host)
+}
+
+// newConfig returns the current config, it is only read again after the config file, directory or environment changed
+func (l *LinstorDriver) newConfig() (*LinstorConfig, error) {
+	stamp := l.configStamp()
+	l.configs.mu.Lock()
+	defer l.configs.mu.Unlock()
+	if l.configs.config == nil || l.configs.stamp != stamp {
+		config, err := l.readConfig()
+		if err != nil {
+			return nil, err
+		}
+		l.configs.stamp, l.configs.config = stamp, config
+	}
+	// callers may change their copy
+	config := *l.configs.config
+	return &config, nil
+}
+
+func (l *LinstorDriver) readConfig() (*LinstorConfig, error) {
+	config := new(LinstorConfig)
+	if err := l.loadConfig(config); err != nil {
+		return nil, err
+	}
+
+	err := envconfig.InitWithOptions(config, envconfig.Options{Prefix: "LS", AllOptional: true})
+	if err != nil {
+		return nil, err
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultMaxRetries
+	}
+	if config.DeviceTimeout <= 0 {
+		config.DeviceTimeout = defaultDeviceTimeout
+	}
+	if config.DefaultHTTPPort == 0 {
+		config.DefaultHTTPPort = defaultHTTPPort
+	}
+	if config.DefaultHTTPSPort == 0 {
+		config.DefaultHTTPSPort = defaultHTTPSPort
+	}
+	for _, port := range []int{config.DefaultHTTPPort, config.DefaultHTTPSPort} {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("Default controller port %d is out of range 1-65535", port)
+		}
+	}
+	return config, nil
+}
+
+// newContext bounds an operation by the configured request timeout
+func (l *LinstorDriver) newContext() (context.Context, context.CancelFunc) {
+	timeout := defaultRequestTimeout
+	// a broken config is reported by newClient
+	if config, err := l.newConfig(); err == nil {
+		timeout = config.RequestTimeout
+	}
+	return context.WithTimeout(l.ctx, timeout)
+}
+
+// unixBaseURL is what requests over a unix socket are addressed to, the host is never resolved
+var unixBaseURL = &url.URL{Scheme: "http", Host: "localhost"}
+
+// unixClient sends all requests to the controller listening on the socket path
+func unixClient(path string) *http.Client {
+	var dialer net.Dialer
+	return &http.Client{Transport: unavailableTransport{&http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}}
+}
+
+// timeoutError names the step that ran into the request timeout
+func timeoutError(step string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out: %w", step, err)
+	}
+	return err
+}
+
+// newClient returns the cached client as long as the controllers, credentials and TLS files stay the same
+func (l *LinstorDriver) newClient() (*client.Client, error) {
+	config, err := l.newConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := l.tlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// the TLS config is only rebuilt if one of its files changed
+	key := fmt.Sprintf("%s|%d|%d|%s|%s|%p", config.Controllers, config.DefaultHTTPPort, config.DefaultHTTPSPort, config.Username, config.Password, tlsConfig)
+	if c := l.clients.get(key); c != nil {
+		return c, nil
+	}
+	c, err := l.dialClient(config, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	l.clients.set(key, c)
+	return c, nil
+}
+
+// dialClient creates a client for the first reachable controller
+func (l *LinstorDriver) dialClient(config *LinstorConfig, tlsConfig *tls.Config) (*client.Client, error) {
+	baseURLs, err := l.newBaseURLs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Transport: unavailableTransport{&http.Transport{TLSClientConfig: tlsConfig}}}
+	var lastErr error
+	for _, baseURL := range baseURLs {
+		name, u, hc := baseURL.Host, baseURL, httpClient
+		if baseURL.Scheme == "unix" {
+			name, u, hc = baseURL.Path, unixBaseURL, unixClient(baseURL.Path)
+		}
+		c, err := client.NewClient(
+			client.BaseURL(u),
+			client.BasicAuth(&client.BasicAuthCfg{Username: config.Username, Password: config.Password}),
+			client.HTTPClient(hc),
+		)
+		if err != nil {
+			return nil, err
+		}
+		// nothing to fail over to, the actual operation reports connection errors
+		if len(baseURLs) == 1 {
+			return c, nil
+		}
+
+		ctx, cancel := context.WithTimeout(l.ctx, controllerProbeTimeout)
+		// golinstor has no version call, a single node is the cheapest thing to ask the controller for
+		_, err = c.Nodes.GetAll(ctx, &client.ListOpts{PerPage: 1})
+		cancel()
+		if err == nil {
+			return c, nil
+		}
+		warnf("Controller '%s' is unreachable: %v", name, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("None of the controllers '%s' is reachable: %w", config.Controllers, lastErr)
+}
+
+// optionHook prepares Docker's options, which are always strings, for weakly typed decoding. Lists are split on
+// white space without empty entries, numbers and booleans may be surrounded by blanks, and booleans take yes/no and
+// on/off besides what strconv.ParseBool accepts.
+func optionHook(from, to reflect.Kind, data interface{}) (interface{}, error) {
+	s, ok := data.(string)
+	if !ok || from != reflect.String {
+		return data, nil
+	}
+	switch to {
+	case reflect.Slice:
+		return strings.Fields(s), nil
+	case reflect.Bool:
+		switch s = strings.ToLower(strings.TrimSpace(s)); s {
+		case "yes", "on":
+			return true, nil
+		case "no", "off":
+			return false, nil
+		}
+		return s, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strings.TrimSpace(s), nil
+	}
+	return data, nil
+}
+
+func (l *LinstorDriver) newParams(name string, options map[string]string) (*LinstorParams, error) {
+	config, err := l.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	// defaults that can be overwritten by config and options, even with empty values
+	params := &LinstorParams{
+		Subdir:              datadir,
+		AutoResize:          true,
+		StoragePool:         config.StoragePool,
+		DisklessStoragePool: config.DisklessStoragePool,
+		Replicas:            config.Replicas,
+	}
+	if err := l.loadConfig(params); err != nil {
+		return nil, err
+	}
+	if options != nil {
+		// ZeroFields makes lists given as option replace those from the config instead of being written over them
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{Result: params, WeaklyTypedInput: true, ZeroFields: true, DecodeHook: optionHook})
+		if err != nil {
+			return nil, err
+		}
+		if err = decoder.Decode(options); err != nil {
+			return nil, fmt.Errorf("Could not parse options of volume '%s': %w", name, err)
+		}
+	}
+	for key, val := range options {
+		var prop string
+		switch {
+		case strings.HasPrefix(key, propOptionPrefix):
+			prop = strings.TrimPrefix(key, propOptionPrefix)
+		case strings.HasPrefix(key, auxOptionPrefix):
+			prop = strings.TrimPrefix(key, auxOptionPrefix)
+			if prop != "" {
+				prop = linstor.NamespcAuxiliary + "/" + prop
+			}
+		default:
+			continue
+		}
+		if prop == "" {
+			return nil, fmt.Errorf("Option '%s' is missing the property name", key)
+		}
+		if isReservedProp(prop) {
+			return nil, fmt.Errorf("Property '%s' is managed by the plugin and can not be set via '%s'", prop, key)
+		}
+		if params.Props == nil {
+			params.Props = make(map[string]string)
+		}
+		params.Props[prop] = val
+	}
+	// nodes may be separated by commas as well
+	var migrateTo []string
+	for _, node := range params.MigrateTo {
+		migrateTo = append(migrateTo, strings.FieldsFunc(node, func(r rune) bool { return r == ',' })...)
+	}
+	params.MigrateTo = migrateTo
+	// empty means the root of the file system, which is "." after cleaning
+	params.Subdir = filepath.Clean(params.Subdir)
+	if filepath.IsAbs(params.Subdir) || params.Subdir == ".." || strings.HasPrefix(params.Subdir, "../") {
+		return nil, fmt.Errorf("Option 'subdir' has to be relative to the volume, got '%s'", params.Subdir)
+	}
+	if params.SnapshotOf != "" && params.RestoreFrom != "" {
+		return nil, errors.New("Options 'snapshot-of' and 'restore-from' are mutually exclusive")
+	}
+	if params.RestoreFrom != "" {
+		parts := strings.SplitN(params.RestoreFrom, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Could not parse restore-from '%s', expected <volume>/<snapshot>", params.RestoreFrom)
+		}
+		params.RestoreVolume, params.RestoreSnapshot = parts[0], parts[1]
+	}
+	for _, name := range params.LayerList {
+		layer, ok := knownLayers[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown layer '%s' in layer-list", name)
+		}
+		params.Layers = append(params.Layers, layer)
+	}
+	if params.NVMe {
+		if len(params.Layers) == 0 {
+			params.Layers = []client.LayerType{client.NVME, client.STORAGE}
+		} else if !hasLayer(params.Layers, client.NVME) {
+			return nil, errors.New("Option 'nvme' requires 'nvme' in layer-list")
+		}
+	}
+	if params.Encryption {
+		if len(params.Layers) == 0 {
+			params.Layers = []client.LayerType{client.DRBD, client.LUKS, client.STORAGE}
+		} else if !hasLayer(params.Layers, client.LUKS) {
+			return nil, errors.New("Option 'encryption' requires 'luks' in layer-list")
+		}
+	}
+	// size conversion
+	if params.Size == "" {
+		params.Size = "100MiB"
+	}
+	params.SizeKiB, err = parseSizeKiB(params.Size)
+	if err != nil {
+		return nil, fmt.Errorf("Could not convert '%s': %v", params.Size, err)
+	}
+	var lower uint64 = 4 * kib
+	if config.MinSize != "" {
+		if lower, err = parseSizeKiB(config.MinSize); err != nil {
+			return nil, fmt.Errorf("Could not convert minimum size '%s': %v", config.MinSize, err)
+		}
+	}
+	if params.SizeKiB < lower {
+		// only tell about sizes that were asked for, not the default
+		if _, ok := options["size"]; ok {
+			if config.StrictSize {
+				return nil, fmt.Errorf("Size '%s' is below the minimum of %s", params.Size, formatKiB(lower))
+			}
+			infof("Raising size '%s' of volume '%s' to the minimum of %s", params.Size, name, formatKiB(lower))
+		}
+		params.SizeKiB = lower
+	}
+	// additional volume definitions get the same minimum
+	for _, size := range params.Volumes {
+		sizeKiB, err := parseSizeKiB(size)
+		if err != nil {
+			return nil, fmt.Errorf("Could not convert '%s' in volumes: %v", size, err)
+		}
+		if sizeKiB < lower {
+			if config.StrictSize {
+				return nil, fmt.Errorf("Size '%s' in volumes is below the minimum of %s", size, formatKiB(lower))
+			}
+			sizeKiB = lower
+		}
+		params.VolumesKiB = append(params.VolumesKiB, sizeKiB)
+	}
+	// a gross size includes the DRBD metadata, what is left has to reach the minimum as well
+	if params.GrossSize {
+		if len(params.Layers) > 0 && !hasLayer(params.Layers, client.DRBD) {
+			return nil, errors.New("Option 'gross-size' accounts for DRBD metadata, the volume has no DRBD layer")
+		}
+		peers := int(params.PeerSlots)
+		if peers == 0 {
+			peers = defaultPeerSlots
+		}
+		metadata := drbdMetadataKiB(params.SizeKiB, peers)
+		if params.SizeKiB < lower+metadata {
+			return nil, fmt.Errorf("Gross size %s leaves less than the minimum of %s after about %s of DRBD metadata", formatKiB(params.SizeKiB), formatKiB(lower), formatKiB(metadata))
+		}
+		debugf("Volume '%s' has a gross size of %s, about %s of it are usable", name, formatKiB(params.SizeKiB), formatKiB(params.SizeKiB-metadata))
+	}
+	if params.FS == "" { params.FS = "ext4" }
+	if err := checkFS(params, options); err != nil {
+		return nil, err
+	}
+	if _, ok := options["replicas"]; ok && params.Replicas < 1 {
+		return nil, fmt.Errorf("Option 'replicas' has to be at least 1, got %d", params.Replicas)
+	}
+	if params.Replicas < 0 {
+		return nil, fmt.Errorf("Default 'replicas' has to be at least 1, got %d", params.Replicas)
+	}
+	if params.Replicas == 0 { params.Replicas = 2 }
+	if params.TieBreaker && isNVMeOnly(params.Layers) {
+		return nil, errors.New("Option 'tiebreaker' needs DRBD, NVMe-oF has no quorum")
+	}
+	if params.VolumeNumber < 0 {
+		return nil, fmt.Errorf("Option 'volume-number' can not be negative, got %d", params.VolumeNumber)
+	}
+	if _, ok := options["volume-number"]; ok && (params.SnapshotOf != "" || params.RestoreFrom != "" || params.ResourceGroup != "") {
+		return nil, errors.New("Option 'volume-number' only applies to new volume definitions, snapshots and resource groups keep their own")
+	}
+	if len(params.Volumes) > 0 && (params.SnapshotOf != "" || params.RestoreFrom != "" || params.ResourceGroup != "") {
+		return nil, errors.New("Option 'volumes' only applies to new volume definitions, snapshots and resource groups keep their own")
+	}
+	switch params.Quorum {
+	case "", "off", "majority", "all":
+	default:
+		if n, err := strconv.Atoi(params.Quorum); err != nil || n < 1 {
+			return nil, fmt.Errorf("Option 'quorum' has to be off, majority, all or a number of nodes, got '%s'", params.Quorum)
+		}
+	}
+	if params.ResyncAfter == name {
+		return nil, errors.New("Option 'resync-after' can not refer to the volume itself")
+	}
+	switch params.OnNoQuorum {
+	case "", "io-error", "suspend-io":
+	default:
+		return nil, fmt.Errorf("Option 'on-no-quorum' has to be io-error or suspend-io, got '%s'", params.OnNoQuorum)
+	}
+	if _, ok := drbdTransports[params.Transport]; !ok && params.Transport != "" {
+		return nil, fmt.Errorf("Option 'transport' has to be tcp or rdma, got '%s'", params.Transport)
+	}
+	if params.Transport != "" && isNVMeOnly(params.Layers) {
+		return nil, errors.New("Option 'transport' selects the DRBD transport, the volume has no DRBD layer")
+	}
+	// broken templates fail here and not on the first mount
+	if _, err := expandMountOpts(params.MountOpts, name); err != nil {
+		return nil, err
+	}
+	if params.Propagation != "" && !propagationModes[params.Propagation] {
+		return nil, fmt.Errorf("Unknown propagation '%s', expected one of shared, slave, private or unbindable, optionally prefixed with 'r'", params.Propagation)
+	}
+	if params.StoragePoolAuto != "" {
+		if params.StoragePoolAuto != "most-free" {
+			return nil, fmt.Errorf("Option 'storage-pool-auto' has to be most-free, got '%s'", params.StoragePoolAuto)
+		}
+		if _, ok := options["storage-pool"]; ok {
+			return nil, errors.New("Options 'storage-pool' and 'storage-pool-auto' can not be combined")
+		}
+	}
+	// the name ends up in a sysfs write, which takes just the name
+	if params.IOScheduler != "" && !schedulerName.MatchString(params.IOScheduler) {
+		return nil, fmt.Errorf("Option 'io-scheduler' has to be the name of an I/O scheduler like none or mq-deadline, got '%s'", params.IOScheduler)
+	}
+	if params.SnapshotSchedule != "" {
+		if _, err := parseSchedule(params.SnapshotSchedule); err != nil {
+			return nil, err
+		}
+	}
+	if params.SnapshotKeep < 0 {
+		return nil, fmt.Errorf("Option 'snapshot-keep' can not be negative, got %d", params.SnapshotKeep)
+	}
+	// DRBD supports at most 31 peers
+	if _, ok := options["peer-slots"]; ok && (params.PeerSlots < 1 || params.PeerSlots > 31) {
+		return nil, fmt.Errorf("Option 'peer-slots' has to be between 1 and 31, got %d", params.PeerSlots)
+	}
+	if _, ok := options["port"]; ok && (params.Port < 1 || params.Port > 65535) {
+		return nil, fmt.Errorf("Option 'port' has to be between 1 and 65535, got %d", params.Port)
+	}
+	// 2^20 minors, LINSTOR never hands out 0
+	if _, ok := options["minor"]; ok && (params.Minor < 1 || params.Minor > 1<<20-1) {
+		return nil, fmt.Errorf("Option 'minor' has to be between 1 and %d, got %d", 1<<20-1, params.Minor)
+	}
+	for _, node := range params.DisklessNodes {
+		for _, diskful := range params.Nodes {
+			if node == diskful {
+				return nil, fmt.Errorf("Node '%s' can not be in both 'nodes' and 'diskless-nodes'", node)
+			}
+		}
+	}
+	if len(params.Nodes) > 0 {
+		// explicit placement, the diskful replicas are exactly the given nodes
+		if _, ok := options["replicas"]; ok && int(params.Replicas) != len(params.Nodes) {
+			return nil, fmt.Errorf("Option 'replicas' (%d) does not match the number of 'nodes' (%d)", params.Replicas, len(params.Nodes))
+		}
+		if params.DisklessOnRemaining {
+			return nil, errors.New("Option 'diskless-on-remaining' only applies to autoplacement, it can not be combined with 'nodes'")
+		}
+	}
+	return params, nil
+}
+
+// checkFS validates the file system options, volumes without file system have no directory to hand out either
+func checkFS(params *LinstorParams, options map[string]string) error {
+	if params.FS == rawFS {
+		for _, opt := range []string{"fsopts", "fs-label", "fs-blocksize", "mkfs-force", "quota", "subdir", "discard"} {
+			if _, ok := options[opt]; ok {
+				return fmt.Errorf("Option '%s' needs a file system, it can not be combined with fs=none", opt)
+			}
+		}
+		params.Subdir = ""
+		return nil
+	}
+	// ZFS storage pools hand out ZVOLs, which are block devices like any other
+	if params.FS == "zfs" {
+		return errors.New("Unsupported file system 'zfs', volumes on ZFS storage pools are ZVOLs and need fs=ext4 or fs=xfs on top")
+	}
+	if !supportedFS[params.FS] {
+		return fmt.Errorf("Unsupported file system '%s', LINSTOR can create ext4 and xfs, fs=none skips the file system", params.FS)
+	}
+	if len(params.FSLabel) > maxLabelLength[params.FS] {
+		return fmt.Errorf("Option 'fs-label' can have at most %d characters on %s, got '%s'", maxLabelLength[params.FS], params.FS, params.FSLabel)
+	}
+	// LINSTOR splits the mkfs parameters on white space
+	if strings.ContainsAny(params.FSLabel, " \t\n") {
+		return fmt.Errorf("Option 'fs-label' can not contain white space, got '%s'", params.FSLabel)
+	}
+	if params.Quota != "" {
+		if params.FS != "xfs" {
+			return fmt.Errorf("Option 'quota' needs fs=xfs, got fs=%s", params.FS)
+		}
+		quota, err := parseSizeKiB(params.Quota)
+		if err != nil {
+			return fmt.Errorf("Could not parse quota: %w", err)
+		}
+		if quota == 0 {
+			return errors.New("Option 'quota' has to be larger than 0")
+		}
+		params.QuotaKiB = quota
+	}
+	if params.FSBlockSize != 0 {
+		var sizes []string
+		for _, size := range blockSizes[params.FS] {
+			if size == params.FSBlockSize {
+				return nil
+			}
+			sizes = append(sizes, strconv.Itoa(size))
+		}
+		return fmt.Errorf("Option 'fs-blocksize' has to be one of %s on %s, got %d", strings.Join(sizes, ", "), params.FS, params.FSBlockSize)
+	}
+	return nil
+}
+
+func (l *LinstorDriver) Create(req *volume.CreateRequest) (err error) {
+	defer metrics.observe("create", time.Now(), &err)
+	defer logError("Create", req.Name, &err)
+	done, err := l.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	params, err := l.newParams(req.Name, req.Options)
+	if err != nil { return err }
+	debugf("Creating volume '%s' with %+v", req.Name, *params)
+	c, err := l.newClient()
+	if err != nil { return err }
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	if params.DryRun {
+		if err := l.dryRun(ctx, c, params); err != nil {
+			return err
+		}
+		infof("Dry run of volume '%s' succeeded, nothing was created", req.Name)
+		return nil
+	}
+
+	// an existing volume can only be adopted, grown or moved
+	var resdef client.ResourceDefinition
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resdef, err = c.ResourceDefinitions.Get(ctx, req.Name)
+		return err
+	})
+	if err == nil {
+		if params.RotatePassphrase {
+			return l.rotatePassphrase(ctx, c, req, resdef)
+		}
+		if params.Adopt {
+			return l.adopt(ctx, c, req, params, resdef)
+		}
+		if len(params.MigrateTo) > 0 {
+			return l.migrate(ctx, c, req, params, resdef)
+		}
+		return l.resize(ctx, c, req, params, resdef)
+	} else if err != client.NotFoundError {
+		return timeoutError("get resource definition", err)
+	}
+	if len(params.MigrateTo) > 0 {
+		return fmt.Errorf("Volume '%s' does not exist, 'migrate-to' only applies to existing volumes", req.Name)
+	}
+	if params.Adopt {
+		return fmt.Errorf("Resource definition '%s' does not exist, there is nothing to adopt", req.Name)
+	}
+	if params.RotatePassphrase {
+		return fmt.Errorf("Volume '%s' does not exist, 'rotate-passphrase' only applies to existing volumes", req.Name)
+	}
+	if err := l.autoStoragePool(ctx, c, params); err != nil {
+		return err
+	}
+	// DRBD refers to the volume of the other resource
+	resyncAfter := ""
+	if params.ResyncAfter != "" {
+		var other client.ResourceDefinition
+		err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+			other, err = c.ResourceDefinitions.Get(ctx, params.ResyncAfter)
+			return err
+		})
+		if err == client.NotFoundError {
+			return fmt.Errorf("Resource '%s' of option 'resync-after' does not exist", params.ResyncAfter)
+		} else if err != nil {
+			return timeoutError("get resource definition", err)
+		}
+		resyncAfter = fmt.Sprintf("%s/%d", other.Name, volumeNumber(other.Props))
+	}
+	defer l.notify("create", req.Name, &err)
+
+	// build props
+	// fsopts are persisted for LINSTOR, which creates the file system
+	props := map[string]string{pluginFlagKey: pluginFlagValue, pluginFSTypeKey: params.FS}
+	if params.FS == rawFS {
+		// without FileSystem/Type LINSTOR leaves the device alone
+		delete(props, pluginFSTypeKey)
+		props[pluginFSKey] = rawFS
+	}
+	mkfsParams := params.FSOpts
+	if params.FSLabel != "" {
+		mkfsParams = strings.TrimSpace(mkfsParams + " -L " + params.FSLabel)
+	}
+	if params.FSBlockSize != 0 {
+		mkfsParams = strings.TrimSpace(mkfsParams + " " + fmt.Sprintf(blockSizeFlags[params.FS], params.FSBlockSize))
+	}
+	if params.MkfsForce {
+		warnf("Volume '%s' is created with mkfs-force, mkfs overwrites whatever data is on its device", req.Name)
+		mkfsParams = strings.TrimSpace(mkfsForceFlags[params.FS] + " " + mkfsParams)
+	}
+	if mkfsParams != "" {
+		props[pluginMkfsParamsKey] = mkfsParams
+	}
+	for key, val := range drbdProps(params, resyncAfter) {
+		props[key] = val
+	}
+	// not a DRBD option, LINSTOR picks the node that becomes primary first by it
+	if params.PrimarySetOn != "" {
+		props["DrbdPrimarySetOn"] = params.PrimarySetOn
+	}
+	for key, val := range optionProps(req.Options, params) {
+		props[key] = val
+	}
+	props[pluginSubdirKey] = params.Subdir
+	for key, val := range params.Props {
+		props[key] = val
+	}
+
+	// whatever gets created from here on is removed again if a later step fails, a volume that does not become
+	// UpToDate in time included. NVMe-oF volumes without DRBD never sync, there is no disk state to wait for.
+	rb := &rollback{name: req.Name}
+	defer rb.run(l, &err)
+	if err := l.createVolume(ctx, c, rb, req, params, props); err != nil {
+		return err
+	}
+	if !isNVMeOnly(params.Layers) {
+		if err := l.waitReady(req.Name, int(params.VolumeNumber)); err != nil {
+			return err
+		}
+	}
+
+	// only new volumes get a schedule, it is persisted with them
+	if params.SnapshotSchedule != "" {
+		l.scheduleSnapshots(req.Name, params.SnapshotSchedule, params.SnapshotKeep)
+	}
+	return nil
+}
+
+// createVolume creates the LINSTOR objects of a new volume, every one of them is added to rb
+func (l *LinstorDriver) createVolume(ctx context.Context, c *client.Client, rb *rollback, req *volume.CreateRequest, params *LinstorParams, props map[string]string) error {
+	if params.SnapshotOf != "" || params.RestoreFrom != "" || params.ResourceGroup != "" {
+		for opt, set := range map[string]bool{"peer-slots": params.PeerSlots != 0, "port": params.Port != 0, "minor": params.Minor != 0, "gross-size": params.GrossSize} {
+			if set {
+				warnf("Ignoring option '%s' for volume '%s', it only applies to newly defined volumes", opt, req.Name)
+			}
+		}
+	}
+	if params.SnapshotOf != "" {
+		debugf("Creating volume '%s' as snapshot of '%s'", req.Name, params.SnapshotOf)
+		return l.snapshotCreate(ctx, c, rb, req, params, props)
+	}
+	if params.RestoreFrom != "" {
+		debugf("Restoring volume '%s' from '%s'", req.Name, params.RestoreFrom)
+		return l.restoreCreate(ctx, c, rb, req, params, props)
+	}
+	if params.ResourceGroup != "" {
+		debugf("Spawning volume '%s' from resource group '%s'", req.Name, params.ResourceGroup)
+		return l.resourceGroupCreate(ctx, c, rb, req, params, props)
+	}
+
+	if params.Encryption {
+		if err := l.enterPassphrase(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	// no half placed volume because of a typo
+	if err := l.checkNodes(ctx, c, append(append([]string(nil), params.Nodes...), params.DisklessNodes...)); err != nil {
+		return err
+	}
+
+	// resource definition, golinstor can not pass the peer slots directly, LINSTOR takes them from the props
+	if params.PeerSlots != 0 {
+		props[linstor.NamespcDrbdOptions+"/"+linstor.KeyPeerSlotsNewResource] = strconv.Itoa(int(params.PeerSlots))
+	}
+	debugf("Creating resource definition '%s' with props %v", req.Name, props)
+	err := l.retry(ctx, c, false, func(c *client.Client) error {
+		return c.ResourceDefinitions.Create(ctx, client.ResourceDefinitionCreate{
+			DrbdPort:           params.Port,
+			DrbdTransportType:  drbdTransports[params.Transport],
+			ResourceDefinition: client.ResourceDefinition{Name: req.Name, Props: props, LayerData: toLayerData(params.Layers)},
+		})
+	})
+	if err != nil {
+		if params.Port != 0 {
+			return fmt.Errorf("Could not create volume '%s' with DRBD port %d, the port might be in use already: %w", req.Name, params.Port, timeoutError("create resource definition", err))
+		}
+		return timeoutError("create resource definition", err)
+	}
+	rb.add("resource definition", func(ctx context.Context) error {
+		return c.ResourceDefinitions.Delete(ctx, req.Name)
+	})
+
+	// volume definition (size), LINSTOR takes it as usable size unless it is flagged as gross
+	var volumeFlags []string
+	if params.GrossSize {
+		volumeFlags = []string{grossSizeFlag}
+	}
+	debugf("Creating volume definition of '%s' with %d KiB", req.Name, params.SizeKiB)
+	err = l.retry(ctx, c, false, func(c *client.Client) error {
+		return c.ResourceDefinitions.CreateVolumeDefinition(ctx, req.Name, client.VolumeDefinitionCreate{
+			VolumeDefinition: client.VolumeDefinition{VolumeNumber: params.VolumeNumber, SizeKib: params.SizeKiB, Flags: volumeFlags},
+			DrbdMinorNumber:  params.Minor,
+		})
+	})
+	if err != nil {
+		if params.Minor != 0 {
+			return fmt.Errorf("Could not create volume '%s' with DRBD minor %d, the minor might be in use already: %w", req.Name, params.Minor, timeoutError("create volume definition", err))
+		}
+		return timeoutError("create volume definition", err)
+	}
+	rb.add("volume definition", func(ctx context.Context) error {
+		return c.ResourceDefinitions.DeleteVolumeDefinition(ctx, req.Name, int(params.VolumeNumber))
+	})
+	// additional volumes follow the mounted one, they are replicated with it and go with the resource definition
+	for i, sizeKiB := range params.VolumesKiB {
+		volNr := params.VolumeNumber + int32(i) + 1
+		debugf("Creating volume definition %d of '%s' with %d KiB", volNr, req.Name, sizeKiB)
+		err = l.retry(ctx, c, false, func(c *client.Client) error {
+			return c.ResourceDefinitions.CreateVolumeDefinition(ctx, req.Name, client.VolumeDefinitionCreate{
+				VolumeDefinition: client.VolumeDefinition{VolumeNumber: volNr, SizeKib: sizeKiB, Flags: volumeFlags},
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("Could not create volume %d of '%s': %w", volNr, req.Name, timeoutError("create volume definition", err))
+		}
+	}
+
+	// place resources, a failed placement might still have created some of them
+	debugf("Placing resources of '%s'", req.Name)
+	rb.add("resources", func(ctx context.Context) error {
+		return deleteResources(ctx, c, req.Name)
+	})
+	placeCtx, placeCancel := l.placeContext(ctx, params)
+	defer placeCancel()
+	if err := l.resourcesCreate(placeCtx, c, req, params); err != nil {
+		return l.capacityError(c, params, timeoutError("place resources", err))
+	}
+	// the request timeout might be used up by mkfs already
+	return l.prewarm(placeCtx, c, req.Name, params)
+}
+
+// placeContext bounds the placement of a new volume, including the diskless resources created in advance, by
+// LS_MKFS_TIMEOUT if LINSTOR formats it, large file systems take longer to create than a request usually does
+func (l *LinstorDriver) placeContext(ctx context.Context, params *LinstorParams) (context.Context, context.CancelFunc) {
+	config, err := l.newConfig()
+	if err != nil || config.MkfsTimeout <= 0 || params.FS == rawFS {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(l.ctx, config.MkfsTimeout)
+}
+
+// prewarm creates diskless resources on the diskless-nodes that did not get a replica, so mounting there does not
+// have to attach the volume first
+func (l *LinstorDriver) prewarm(ctx context.Context, c *client.Client, name string, params *LinstorParams) error {
+	if len(params.DisklessNodes) == 0 {
+		return nil
+	}
+	diskful, diskless, err := l.replicaNodes(ctx, c, name, int(params.VolumeNumber))
+	if err != nil {
+		return err
+	}
+	for _, node := range params.DisklessNodes {
+		if diskful[node] || diskless[node] {
+			continue
+		}
+		debugf("Creating diskless resource of volume '%s' on node '%s' in advance", name, node)
+		create := l.toDisklessCreate(name, node, params)
+		if err := l.retry(ctx, c, false, func(c *client.Client) error { return c.Resources.Create(ctx, create) }); err != nil {
+			return fmt.Errorf("Could not create diskless resource of volume '%s' on node '%s': %w", name, node, timeoutError("create diskless resource", err))
+		}
+	}
+	return nil
+}
+
+// resize grows the volume definition of an existing volume, the file system follows on the next Mount
+func (l *LinstorDriver) resize(ctx context.Context, c *client.Client, req *volume.CreateRequest, params *LinstorParams, resdef client.ResourceDefinition) error {
+	if resdef.Props[pluginFlagKey] != pluginFlagValue {
+		return fmt.Errorf("Volume '%s' is not managed by this plugin", req.Name)
+	}
+	if _, ok := req.Options["size"]; !ok {
+		return fmt.Errorf("Volume '%s' already exists", req.Name)
+	}
+	volNr := volumeNumber(resdef.Props)
+	voldef, err := getVolumeDefinition(ctx, c, req.Name, volNr)
+	if err != nil {
+		return timeoutError("get volume definition", err)
+	}
+	if params.SizeKiB < voldef.SizeKib {
+		return fmt.Errorf("Volume '%s' can not shrink from %d KiB to %d KiB", req.Name, voldef.SizeKib, params.SizeKiB)
+	}
+	if params.SizeKiB == voldef.SizeKib {
+		return nil
+	}
+	debugf("Resizing volume '%s' from %d KiB to %d KiB", req.Name, voldef.SizeKib, params.SizeKiB)
+	err = c.ResourceDefinitions.ModifyVolumeDefinition(ctx, req.Name, volNr, client.VolumeDefinitionModify{SizeKib: params.SizeKiB})
+	return timeoutError("resize volume definition", err)
+}
+
+// resourceGroupCreate spawns the volume from a resource group, placement is up to its select filter
+func (l *LinstorDriver) resourceGroupCreate(ctx context.Context, c *client.Client, rb *rollback, req *volume.CreateRequest, params *LinstorParams, props map[string]string) error {
+	for _, key := range []string{"nodes", "replicas", "storage-pool", "storage-pool-auto", "diskless-on-remaining"} {
+		if _, ok := req.Options[key]; ok {
+			warnf("Ignoring option '%s' for volume '%s', placement is defined by resource group '%s'", key, req.Name, params.ResourceGroup)
+		}
+	}
+	// spawning takes no transport, volumes of the group use the DRBD default
+	if params.Transport != "" {
+		warnf("Ignoring option 'transport' for volume '%s', it can not be set when spawning from resource group '%s'", req.Name, params.ResourceGroup)
+	}
+
+	// per volume placement tweaks on top of the group, they must not contradict it
+	filter := client.AutoSelectFilter{
+		ReplicasOnSame:       params.ReplicasOnSame,
+		ReplicasOnDifferent:  params.ReplicasOnDifferent,
+		NotPlaceWithRscRegex: params.DoNotPlaceWithRegex,
+	}
+	if len(filter.ReplicasOnSame) > 0 || len(filter.ReplicasOnDifferent) > 0 {
+		group, err := c.ResourceGroups.Get(ctx, params.ResourceGroup)
+		if err != nil {
+			return timeoutError("get resource group", err)
+		}
+		if key := conflictingFilterKey(filter.ReplicasOnSame, group.SelectFilter.ReplicasOnDifferent); key != "" {
+			return fmt.Errorf("Option 'replicas-on-same' conflicts with 'replicas-on-different' of resource group '%s' for '%s'", params.ResourceGroup, key)
+		}
+		if key := conflictingFilterKey(filter.ReplicasOnDifferent, group.SelectFilter.ReplicasOnSame); key != "" {
+			return fmt.Errorf("Option 'replicas-on-different' conflicts with 'replicas-on-same' of resource group '%s' for '%s'", params.ResourceGroup, key)
+		}
+	}
+
+	// definitions only, the file system props have to be set before any resource gets deployed
+	err := c.ResourceGroups.Spawn(ctx, params.ResourceGroup, client.ResourceGroupSpawn{
+		ResourceDefinitionName: req.Name,
+		VolumeSizes:            []int64{int64(params.SizeKiB)},
+		DefinitionsOnly:        true,
+	})
+	if err != nil {
+		return timeoutError("spawn from resource group", err)
+	}
+	rb.add("resource definition", func(ctx context.Context) error {
+		return c.ResourceDefinitions.Delete(ctx, req.Name)
+	})
+	if err := c.ResourceDefinitions.Modify(ctx, req.Name, client.GenericPropsModify{OverrideProps: props}); err != nil {
+		return timeoutError("set resource definition props", err)
+	}
+
+	// unset fields inherit the select filter of the resource group
+	rb.add("resources", func(ctx context.Context) error {
+		return deleteResources(ctx, c, req.Name)
+	})
+	if err := c.Resources.Autoplace(ctx, req.Name, client.AutoPlaceRequest{LayerList: params.Layers, SelectFilter: filter}); err != nil {
+		// golinstor has no error type for LINSTOR refusing the placement, anything but a transport failure is one
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || isTransient(err, true) {
+			return timeoutError("autoplace", err)
+		}
+		return fmt.Errorf("Could not place volume '%s' with resource group '%s': %w", req.Name, params.ResourceGroup, err)
+	}
+	return nil
+}
+
+// conflictingFilterKey returns the first property both lists refer to, "Aux/" prefixes and values are ignored
+func conflictingFilterKey(a, b []string) string {
+	keys := make(map[string]bool)
+	for _, entry := range b {
+		keys[filterKey(entry)] = true
+	}
+	for _, entry := range a {
+		if keys[filterKey(entry)] {
+			return filterKey(entry)
+		}
+	}
+	return ""
+}
+
+func filterKey(entry string) string {
+	key := strings.SplitN(entry, "=", 2)[0]
+	return strings.TrimPrefix(key, "Aux/")
+}
+
+// snapshotCreate takes a snapshot of an existing volume and restores it as a new volume
+func (l *LinstorDriver) snapshotCreate(ctx context.Context, c *client.Client, rb *rollback, req *volume.CreateRequest, params *LinstorParams, props map[string]string) error {
+	if err := l.sourceProps(ctx, c, params.SnapshotOf, props); err != nil {
+		return err
+	}
+
+	// the snapshot is named after the new volume and kept as its point-in-time origin
+	snapshot := client.Snapshot{Name: req.Name, ResourceName: params.SnapshotOf}
+	if err := c.Resources.CreateSnapshot(ctx, snapshot); err != nil {
+		return timeoutError("create snapshot", err)
+	}
+	rb.add("snapshot of '"+params.SnapshotOf+"'", func(ctx context.Context) error {
+		return c.Resources.DeleteSnapshot(ctx, params.SnapshotOf, snapshot.Name)
+	})
+	return l.snapshotRestore(ctx, c, rb, req.Name, params.SnapshotOf, snapshot.Name, drbdTransports[params.Transport], props)
+}
+
+// restoreCreate restores an existing snapshot as a new volume
+func (l *LinstorDriver) restoreCreate(ctx context.Context, c *client.Client, rb *rollback, req *volume.CreateRequest, params *LinstorParams, props map[string]string) error {
+	if err := l.sourceProps(ctx, c, params.RestoreVolume, props); err != nil {
+		return err
+	}
+	// check before anything gets created, so a typo doesn't leave a half-created volume
+	_, err := c.Resources.GetSnapshot(ctx, params.RestoreVolume, params.RestoreSnapshot)
+	if err == client.NotFoundError {
+		return fmt.Errorf("Snapshot '%s' of volume '%s' does not exist", params.RestoreSnapshot, params.RestoreVolume)
+	} else if err != nil {
+		return timeoutError("get snapshot", err)
+	}
+	return l.snapshotRestore(ctx, c, rb, req.Name, params.RestoreVolume, params.RestoreSnapshot, drbdTransports[params.Transport], props)
+}
+
+// sourceProps makes sure the source volume is ours and copies its file system into props
+func (l *LinstorDriver) sourceProps(ctx context.Context, c *client.Client, name string, props map[string]string) error {
+	source, err := c.ResourceDefinitions.Get(ctx, name)
+	if err == client.NotFoundError {
+		return fmt.Errorf("Source volume '%s' does not exist", name)
+	} else if err != nil {
+		return timeoutError("get source volume", err)
+	}
+	if source.Props[pluginFlagKey] != pluginFlagValue {
+		return fmt.Errorf("Source volume '%s' is not managed by this plugin", name)
+	}
+	if source.Props[pluginFSKey] == rawFS {
+		delete(props, pluginFSTypeKey)
+		props[pluginFSKey] = rawFS
+		props[pluginSubdirKey] = ""
+	} else {
+		props[pluginFSTypeKey] = source.Props[pluginFSTypeKey]
+	}
+	if volNr, ok := source.Props[pluginOptionPrefix+"volume-number"]; ok {
+		props[pluginOptionPrefix+"volume-number"] = volNr
+	}
+	return nil
+}
+
+// snapshotRestore creates the resource definition name with the DRBD transport type and populates it from a snapshot
+func (l *LinstorDriver) snapshotRestore(ctx context.Context, c *client.Client, rb *rollback, name, source, snapshot, transport string, props map[string]string) error {
+	create := client.ResourceDefinitionCreate{DrbdTransportType: transport, ResourceDefinition: client.ResourceDefinition{Name: name, Props: props}}
+	if err := c.ResourceDefinitions.Create(ctx, create); err != nil {
+		return timeoutError("create resource definition", err)
+	}
+	// the resource definition takes restored volume definitions and resources with it
+	rb.add("resource definition", func(ctx context.Context) error {
+		return c.ResourceDefinitions.Delete(ctx, name)
+	})
+
+	restore := client.SnapshotRestore{ToResource: name}
+	if err := c.Resources.RestoreVolumeDefinitionSnapshot(ctx, source, snapshot, restore); err != nil {
+		return timeoutError("restore volume definitions", err)
+	}
+	if err := c.Resources.RestoreSnapshot(ctx, source, snapshot, restore); err != nil {
+		return timeoutError("restore snapshot", err)
+	}
+	return nil
+}
+
+// checkNodes makes sure all nodes exist before anything gets placed on them
+func (l *LinstorDriver) checkNodes(ctx context.Context, c *client.Client, nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	var all []client.Node
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		all, err = c.Nodes.GetAll(ctx)
+		return err
+	})
+	if err != nil {
+		return timeoutError("get nodes", err)
+	}
+	known := make(map[string]bool)
+	for _, node := range all {
+		known[node.Name] = true
+	}
+	var unknown []string
+	for _, node := range nodes {
+		if !known[node] {
+			unknown = append(unknown, node)
+		}
+	}
+	switch len(unknown) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("Node '%s' does not exist", unknown[0])
+	default:
+		return fmt.Errorf("Nodes '%s' do not exist", strings.Join(unknown, "', '"))
+	}
+}
+
+// dryRun checks that the requested placement is possible with the current nodes and storage pools, it only reads
+func (l *LinstorDriver) dryRun(ctx context.Context, c *client.Client, params *LinstorParams) error {
+	if params.ResourceGroup != "" {
+		// placement is up to the resource group
+		err := l.retry(ctx, c, true, func(c *client.Client) error {
+			_, err := c.ResourceGroups.Get(ctx, params.ResourceGroup)
+			return err
+		})
+		if err == client.NotFoundError {
+			return fmt.Errorf("Resource group '%s' does not exist", params.ResourceGroup)
+		}
+		return timeoutError("get resource group", err)
+	}
+
+	if err := l.checkNodes(ctx, c, params.Nodes); err != nil {
+		return err
+	}
+	if err := l.autoStoragePool(ctx, c, params); err != nil {
+		return err
+	}
+	var pools []client.StoragePool
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		pools, err = c.Nodes.GetStoragePoolView(ctx)
+		return err
+	})
+	if err != nil {
+		return timeoutError("get storage pools", err)
+	}
+
+	// nodes that could hold a diskful replica
+	candidates := make(map[string]bool)
+	disklessPool := false
+	for _, pool := range pools {
+		if pool.StoragePoolName == params.DisklessStoragePool {
+			disklessPool = true
+		}
+		if pool.ProviderKind == client.DISKLESS {
+			continue
+		}
+		if params.StoragePool == "" || pool.StoragePoolName == params.StoragePool {
+			candidates[pool.NodeName] = true
+		}
+	}
+	if params.DisklessStoragePool != "" && !disklessPool {
+		return fmt.Errorf("Diskless storage pool '%s' does not exist", params.DisklessStoragePool)
+	}
+	if params.StoragePool != "" && len(candidates) == 0 {
+		return fmt.Errorf("Storage pool '%s' does not exist", params.StoragePool)
+	}
+	for _, node := range params.Nodes {
+		if !candidates[node] {
+			return fmt.Errorf("Node '%s' has no suitable storage pool for a diskful replica", node)
+		}
+	}
+	if len(params.Nodes) == 0 && int(params.Replicas) > len(candidates) {
+		return fmt.Errorf("Can not place %d replicas, only %d nodes have a suitable storage pool", params.Replicas, len(candidates))
+	}
+	return nil
+}
+
+// autoStoragePool sets params.StoragePool for storage-pool-auto=most-free: of the pools that have room for the volume
+// on enough of the eligible nodes, the one with the most free space on them in total. Ties go to the first name.
+func (l *LinstorDriver) autoStoragePool(ctx context.Context, c *client.Client, params *LinstorParams) error {
+	if params.StoragePoolAuto == "" || params.ResourceGroup != "" {
+		return nil
+	}
+	var pools []client.StoragePool
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		pools, err = c.Nodes.GetStoragePoolView(ctx)
+		return err
+	})
+	if err != nil {
+		return timeoutError("get storage pools", err)
+	}
+
+	nodes := make(map[string]bool)
+	for _, node := range params.Nodes {
+		nodes[node] = true
+	}
+	fitting := make(map[string]int)
+	free := make(map[string]int64)
+	for _, pool := range pools {
+		if pool.ProviderKind == client.DISKLESS || (len(nodes) > 0 && !nodes[pool.NodeName]) ||
+			pool.FreeCapacity < 0 || uint64(pool.FreeCapacity) < params.SizeKiB {
+			continue
+		}
+		fitting[pool.StoragePoolName]++
+		free[pool.StoragePoolName] += pool.FreeCapacity
+	}
+	needed := int(params.Replicas)
+	if len(params.Nodes) > 0 {
+		needed = len(params.Nodes)
+	}
+	var names []string
+	for name := range fitting {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best := ""
+	for _, name := range names {
+		if fitting[name] >= needed && (best == "" || free[name] > free[best]) {
+			best = name
+		}
+	}
+	if best == "" {
+		return fmt.Errorf("No storage pool has %s free on %d eligible node(s)", formatKiB(params.SizeKiB), needed)
+	}
+	debugf("Picked storage pool '%s' with %s free on %d node(s)", best, formatKiB(uint64(free[best])), fitting[best])
+	params.StoragePool = best
+	return nil
+}
+
+// capacityError replaces a failed placement with a clear message if the eligible storage pools are too small,
+// the LINSTOR error does not say much
+func (l *LinstorDriver) capacityError(c *client.Client, params *LinstorParams, err error) error {
+	// the request context might be expired already
+	ctx, cancel := context.WithTimeout(l.ctx, controllerProbeTimeout)
+	defer cancel()
+	pools, perr := c.Nodes.GetStoragePoolView(ctx)
+	if perr != nil {
+		return err
+	}
+
+	nodes := make(map[string]bool)
+	for _, node := range params.Nodes {
+		nodes[node] = true
+	}
+	var largest int64
+	var enough int
+	for _, pool := range pools {
+		if pool.ProviderKind == client.DISKLESS ||
+			(params.StoragePool != "" && pool.StoragePoolName != params.StoragePool) ||
+			(len(nodes) > 0 && !nodes[pool.NodeName]) {
+			continue
+		}
+		if pool.FreeCapacity > largest {
+			largest = pool.FreeCapacity
+		}
+		if uint64(pool.FreeCapacity) >= params.SizeKiB {
+			enough++
+		}
+	}
+	needed := int(params.Replicas)
+	if len(params.Nodes) > 0 {
+		needed = len(params.Nodes)
+	}
+	if enough >= needed {
+		return err
+	}
+	if largest < 0 {
+		largest = 0
+	}
+	return fmt.Errorf("Requested %s on %d node(s), but only %d eligible storage pool(s) have enough space, the largest has %s free: %w",
+		formatKiB(params.SizeKiB), needed, enough, formatKiB(uint64(largest)), err)
+}
+
+// resourcesCreate places diskfull or diskless based on params
+func (l *LinstorDriver) resourcesCreate(ctx context.Context, c *client.Client, req *volume.CreateRequest, params *LinstorParams) error {
+	if len(params.Nodes) == 0 {
+		err := l.retry(ctx, c, true, func(c *client.Client) error {
+			return c.Resources.Autoplace(ctx, req.Name, client.AutoPlaceRequest{
+				LayerList:           params.Layers,
+				DisklessOnRemaining: params.DisklessOnRemaining,
+				SelectFilter: client.AutoSelectFilter{PlaceCount: params.Replicas, StoragePool: params.StoragePool, NotPlaceWithRscRegex: params.DoNotPlaceWithRegex, ReplicasOnSame: params.ReplicasOnSame, ReplicasOnDifferent: params.ReplicasOnDifferent},
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return l.checkPlacement(ctx, c, req.Name, params)
+	}
+	for _, node := range params.Nodes {
+		create := l.toDiskfullCreate(req.Name, node, params)
+		if err := l.retry(ctx, c, false, func(c *client.Client) error { return c.Resources.Create(ctx, create) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPlacement verifies that the autoplacer created all requested diskful replicas, in a degraded cluster it can
+// report success with fewer of them. With best-effort-placement the volume is kept anyway.
+func (l *LinstorDriver) checkPlacement(ctx context.Context, c *client.Client, name string, params *LinstorParams) error {
+	diskful, _, err := l.replicaNodes(ctx, c, name, int(params.VolumeNumber))
+	if err != nil {
+		return err
+	}
+	if len(diskful) >= int(params.Replicas) {
+		return nil
+	}
+	var nodes []string
+	for node := range diskful {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	placed := strings.Join(nodes, ", ")
+	if placed == "" {
+		placed = "none"
+	}
+	if params.BestEffortPlacement {
+		warnf("Volume '%s' got %d of %d diskful replicas (%s), keeping it because of best-effort-placement", name, len(diskful), params.Replicas, placed)
+		return nil
+	}
+	return fmt.Errorf("Autoplacer placed %d of %d diskful replicas of volume '%s' (on %s)", len(diskful), params.Replicas, name, placed)
+}
+
+func (l *LinstorDriver) Get(req *volume.GetRequest) (_ *volume.GetResponse, err error) {
+	defer logError("Get", req.Name, &err)
+	done, err := l.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	c, err := l.newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	resourceDef, err := l.managedDefinition(ctx, c, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	vol := &volume.Volume{
+		Name:       resourceDef.Name,
+		Mountpoint: l.mountPoint(resourceDef.Name, volumeSubdir(resourceDef.Props)),
+		Status:     l.volumeStatus(ctx, c, resourceDef.Name, volumeNumber(resourceDef.Props)),
+	}
+	vol.Status["mounted"] = l.mountStatus(resourceDef.Name)
+	if aux := auxProps(resourceDef.Props); len(aux) > 0 {
+		vol.Status["aux"] = aux
+	}
+	return &volume.GetResponse{Volume: vol}, nil
+}
+
+// auxProps returns the Aux props of a resource definition without the namespace, except the plugin's own ones
+func auxProps(props map[string]string) map[string]string {
+	aux := make(map[string]string)
+	prefix := linstor.NamespcAuxiliary + "/"
+	for key, val := range props {
+		if strings.HasPrefix(key, prefix) && !isReservedProp(key) {
+			aux[strings.TrimPrefix(key, prefix)] = val
+		}
+	}
+	return aux
+}
+
+// managedDefinition returns the resource definition of volume name. Missing definitions and ones that are not
+// managed by this plugin both result in errNoSuchVolume, Docker does not care about the difference.
+func (l *LinstorDriver) managedDefinition(ctx context.Context, c *client.Client, name string) (client.ResourceDefinition, error) {
+	var resdef client.ResourceDefinition
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resdef, err = c.ResourceDefinitions.Get(ctx, name)
+		return err
+	})
+	if err == client.NotFoundError {
+		return resdef, fmt.Errorf("Volume '%s': %w", name, errNoSuchVolume)
+	} else if err != nil {
+		return resdef, timeoutError("get resource definition", err)
+	}
+	if resdef.Props[pluginFlagKey] != pluginFlagValue {
+		debugf("Resource definition '%s' exists, but is not managed by this plugin", name)
+		return resdef, fmt.Errorf("Volume '%s': %w", name, errNoSuchVolume)
+	}
+	return resdef, nil
+}
+
+// volumeStatus collects size and deployment information, usage is omitted if the volume is not deployed
+func (l *LinstorDriver) volumeStatus(ctx context.Context, c *client.Client, name string, volNr int) map[string]interface{} {
+	status := make(map[string]interface{})
+	voldef, err := getVolumeDefinition(ctx, c, name, volNr)
+	if err != nil {
+		warnf("Could not get volume definition of '%s': %v", name, err)
+		return status
+	}
+	status["size-kib"] = voldef.SizeKib
+	if snapshots := l.snapshotStatus(ctx, c, name); len(snapshots) > 0 {
+		status["snapshots"] = snapshots
+	}
+
+	resources, err := c.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{name}})
+	if err != nil {
+		warnf("Could not get resources of '%s': %v", name, err)
+		return status
+	}
+	if len(resources) == 0 {
+		return status
+	}
+
+	var replicas int
+	var allocated int64
+	state := make(map[string]string)
+	disklessFlags := make(map[string]string)
+	for _, res := range resources {
+		vol, ok := findVolume(res.Volumes, volNr)
+		if !ok {
+			continue
+		}
+		state[res.NodeName] = vol.State.DiskState
+		diskless := vol.ProviderKind == client.DISKLESS
+		if res.NodeName == l.node {
+			status["diskless"] = diskless
+		}
+		if diskless {
+			// tells plain diskless access and tie-breakers apart
+			if len(res.Flags) > 0 {
+				disklessFlags[res.NodeName] = strings.Join(res.Flags, ",")
+			}
+			continue
+		}
+		replicas++
+		if vol.AllocatedSizeKib > allocated {
+			allocated = vol.AllocatedSizeKib
+		}
+	}
+	status["replicas"] = replicas
+	status["allocated-kib"] = allocated
+	status["state"] = state
+	if len(disklessFlags) > 0 {
+		status["diskless-flags"] = disklessFlags
+	}
+	return status
+}
+
+// snapshotStatus lists the snapshots of a volume, oldest first. golinstor does not report when a snapshot was taken,
+// only scheduled snapshots have a creation time, taken from their name. The others come first, sorted by name.
+func (l *LinstorDriver) snapshotStatus(ctx context.Context, c *client.Client, name string) []map[string]string {
+	snaps, err := c.Resources.GetSnapshots(ctx, name)
+	if err != nil {
+		warnf("Could not get snapshots of '%s': %v", name, err)
+		return nil
+	}
+	type entry struct {
+		name    string
+		created time.Time
+	}
+	var entries []entry
+	for _, snap := range snaps {
+		var created time.Time
+		if strings.HasPrefix(snap.Name, autoSnapshotPrefix) {
+			created, _ = time.Parse(autoSnapshotTimeFormat, strings.TrimPrefix(snap.Name, autoSnapshotPrefix))
+		}
+		entries = append(entries, entry{name: snap.Name, created: created})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].created.Equal(entries[j].created) {
+			return entries[i].created.Before(entries[j].created)
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	var snapshots []map[string]string
+	for _, e := range entries {
+		snapshot := map[string]string{"name": e.name}
+		if !e.created.IsZero() {
+			snapshot["created"] = e.created.UTC().Format(time.RFC3339)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func (l *LinstorDriver) List() (_ *volume.ListResponse, err error) {
+	defer logError("List", "", &err)
+	done, err := l.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	config, err := l.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := l.newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	var local map[string]bool
+	if config.ListLocalOnly {
+		if local, err = l.localResources(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	resourceDefs, err := l.managedDefinitions(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	vols := []*volume.Volume{}
+	var failed []string
+	for _, resourceDef := range resourceDefs {
+		if local != nil && !local[resourceDef.Name] {
+			continue
+		}
+		vol, err := l.listVolume(resourceDef)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("'%s' (%v)", resourceDef.Name, err))
+		}
+		vols = append(vols, vol)
+	}
+	if len(failed) > 0 {
+		warnf("Could not check whether %d volumes are mounted: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return &volume.ListResponse{Volumes: vols}, nil
+}
+
+// listVolume describes a volume for List. An error only means that its mount state is unknown, the volume is
+// returned anyway, so one stuck mount does not break docker volume ls.
+func (l *LinstorDriver) listVolume(resourceDef client.ResourceDefinition) (*volume.Volume, error) {
+	vol := &volume.Volume{Name: resourceDef.Name, Status: map[string]interface{}{"mounted": "unknown"}}
+	mounted, err := l.mounted(resourceDef.Name)
+	if err != nil {
+		return vol, err
+	}
+	vol.Status["mounted"] = mounted
+	if mounted {
+		vol.Mountpoint = l.reportedMountPath(resourceDef.Name, volumeSubdir(resourceDef.Props))
+	}
+	return vol, nil
+}
+
+// managedDefinitions returns the resource definitions of all volumes of the plugin. golinstor can not ask the
+// controller to filter by property, so all definitions are fetched and the ones without the plugin flag dropped.
+func (l *LinstorDriver) managedDefinitions(ctx context.Context, c *client.Client) ([]client.ResourceDefinition, error) {
+	var resourceDefs []client.ResourceDefinition
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resourceDefs, err = c.ResourceDefinitions.GetAll(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, timeoutError("list resource definitions", err)
+	}
+	var managed []client.ResourceDefinition
+	for _, resourceDef := range resourceDefs {
+		if resourceDef.Props[pluginFlagKey] == pluginFlagValue {
+			managed = append(managed, resourceDef)
+		}
+	}
+	return managed, nil
+}
+
+// localResources returns the names of the resources on this node
+func (l *LinstorDriver) localResources(ctx context.Context, c *client.Client) (map[string]bool, error) {
+	var resources []client.ResourceWithVolumes
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resources, err = c.Resources.GetResourceView(ctx, &client.ListOpts{Node: []string{l.node}})
+		return err
+	})
+	if err != nil {
+		return nil, timeoutError("get resource view", err)
+	}
+	local := make(map[string]bool)
+	for _, r := range resources {
+		local[r.Name] = true
+	}
+	return local, nil
+}
+
+func (l *LinstorDriver) Remove(req *volume.RemoveRequest) (err error) {
+	defer metrics.observe("remove", time.Now(), &err)
+	defer logError("Remove", req.Name, &err)
+	defer l.notify("remove", req.Name, &err)
+	done, err := l.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	config, err := l.newConfig()
+	if err != nil {
+		return err
+	}
+	c, err := l.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	resdef, err := l.managedDefinition(ctx, c, req.Name)
+	if err != nil {
+		return err
+	}
+
+	if config.RemoveLocal {
+		diskful, diskless, err := l.replicaNodes(ctx, c, req.Name, volumeNumber(resdef.Props))
+		if err != nil {
+			return err
+		}
+		if diskless[l.node] && len(diskful) > 0 {
+			debugf("Only detaching volume '%s' from node '%s', its data is stored elsewhere", req.Name, l.node)
+			return l.remove(req.Name, false)
+		}
+	}
+	if resdef.Props[pluginOptionPrefix+"protect"] == "true" && resdef.Props[pluginConfirmRemoveKey] != "true" {
+		return fmt.Errorf("Volume '%s' is protected, set '%s' to 'true' on its resource definition to remove it", req.Name, pluginConfirmRemoveKey)
+	}
+
+	debugf("Removing volume '%s'", req.Name)
+	if err = l.remove(req.Name, true); err != nil {
+		return err
+	}
+	l.unscheduleSnapshots(req.Name)
+	return nil
+}
+
+func (l *LinstorDriver) Path(req *volume.PathRequest) (_ *volume.PathResponse, err error) {
+	defer logError("Path", req.Name, &err)
+	done, err := l.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	c, err := l.newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	resdef, err := l.managedDefinition(ctx, c, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &volume.PathResponse{Mountpoint: l.mountPoint(req.Name, volumeSubdir(resdef.Props))}, nil
+}
+
+func (l *LinstorDriver) Mount(req *volume.MountRequest) (_ *volume.MountResponse, err error) {
+	defer metrics.observe("mount", time.Now(), &err)
+	defer logError("Mount", req.Name, &err)
+	defer l.notify("mount", req.Name, &err)
+	done, err := l.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	state := l.lockVolume(req.Name)
+	defer l.unlockVolume(req.Name, state)
+	defer l.saveMounts(req.Name, state)
+	// already mounted for another container
+	if state.mounts > 0 {
+		debugf("Volume '%s' is already mounted on node '%s', %d active mounts", req.Name, l.node, state.mounts)
+		state.mounts++
+		return &volume.MountResponse{Mountpoint: l.reportedMountPath(req.Name, state.subdir)}, nil
+	}
+
+	c, err := l.newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	// properties are not merged, so we have to query the resdef
+	// as we set the property there
+	var resdef client.ResourceDefinition
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resdef, err = c.ResourceDefinitions.Get(ctx, req.Name)
+		return err
+	})
+	if err != nil {
+		return nil, timeoutError("get resource definition", err)
+	}
+	// volumes of older versions or adopted ones might not know their file system, it is detected on the device
+	fstype := resdef.Props[pluginFSTypeKey]
+	raw := resdef.Props[pluginFSKey] == rawFS
+	params, err := l.newParams(req.Name, persistedOptions(resdef.Props))
+	if err != nil {
+		return nil, err
+	}
+	// access is created according to the stack of the volume, not the config
+	params.Layers = nil
+	for _, layer := range resdef.LayerData {
+		params.Layers = append(params.Layers, layer.Type)
+	}
+
+	// an existing resource (diskful, or diskless from an earlier mount) is simply used
+	getResource := func() error {
+		return l.retry(ctx, c, true, func(c *client.Client) error {
+			_, err := c.Resources.Get(ctx, req.Name, l.node)
+			return err
+		})
+	}
+	if err = getResource(); err == client.NotFoundError {
+		// diskless access without any peer to get the data from never becomes usable
+		diskful, _, err := l.replicaNodes(ctx, c, req.Name, int(params.VolumeNumber))
+		if err != nil {
+			return nil, err
+		}
+		if len(diskful) == 0 {
+			return nil, fmt.Errorf("Volume '%s' has no diskful replicas, its data is not stored on any node", req.Name)
+		}
+		debugf("Creating diskless resource of volume '%s' on node '%s'", req.Name, l.node)
+		err = l.retry(ctx, c, false, func(c *client.Client) error {
+			return c.Resources.Create(ctx, l.toDisklessCreate(req.Name, l.node, params))
+		})
+		// somebody else might have been faster
+		if err != nil && getResource() != nil {
+			return nil, timeoutError("create diskless resource", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not get resource of volume '%s' on node '%s': %w", req.Name, l.node, timeoutError("get resource", err))
+	}
+	var vol client.Volume
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		vol, err = c.Resources.GetVolume(ctx, req.Name, l.node, int(params.VolumeNumber))
+		return err
+	})
+	if err != nil {
+		return nil, timeoutError("get volume", err)
+	}
+	source := vol.DevicePath
+	config, err := l.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	// udev might not have created a freshly attached device yet
+	if err := waitDevice(source, config.DeviceTimeout); err != nil {
+		return nil, fmt.Errorf("Volume '%s': %w", req.Name, err)
+	}
+	inUse, err := l.mounter.DeviceOpened(source)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, fmt.Errorf("unable to get exclusive open on %s", source)
+	}
+	// a read-only mount does not need a primary, and without DRBD there is nothing to promote
+	if !params.ReadOnly && config.WaitPromotable > 0 && !isNVMeOnly(params.Layers) {
+		if err := l.waitPromotable(c, req.Name, int(params.VolumeNumber), config.WaitPromotable); err != nil {
+			return nil, err
+		}
+	}
+	if !params.ReadOnly && config.WaitPrimary > 0 {
+		if err := waitPrimary(source, config.WaitPrimary); err != nil {
+			return nil, fmt.Errorf("Volume '%s': %w", req.Name, err)
+		}
+	}
+	if params.IOScheduler != "" {
+		setScheduler(source, params.IOScheduler)
+	}
+	if fstype == "" && !raw {
+		if fstype, err = l.detectFS(source, config.DefaultFS); err != nil {
+			return nil, fmt.Errorf("Volume '%s' did not contain a file system key: %w", req.Name, err)
+		}
+	}
+	target := l.realMountPath(req.Name)
+	if raw {
+		if err = l.mountRaw(source, target, params.ReadOnly); err != nil {
+			return nil, err
+		}
+		state.mounts++
+		state.subdir = ""
+		metrics.addMounted(1)
+		return &volume.MountResponse{Mountpoint: target}, nil
+	}
+	if err = l.mounter.MakeDir(target); err != nil {
+		return nil, err
+	}
+	opts, err := expandMountOpts(params.MountOpts, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if params.ReadOnly {
+		opts = append(opts, "ro")
+	}
+	if params.Propagation != "" {
+		opts = append(opts, params.Propagation)
+	}
+	if params.QuotaKiB > 0 {
+		opts = append(opts, "prjquota")
+	}
+	if params.Discard {
+		if supported, err := discardSupported(source); err != nil || !supported {
+			warnf("Mounting volume '%s' without discard, its device does not support it", req.Name)
+		} else {
+			opts = append(opts, "discard")
+		}
+	}
+	debugf("Mounting '%s' (%s) on '%s' with options %v", source, fstype, target, opts)
+	err = l.mounter.Mount(source, target, fstype, opts)
+	if err != nil {
+		return nil, err
+	}
+	// all or nothing, a failure after this point must not leave the device mounted
+	defer func() {
+		if err == nil {
+			return
+		}
+		if uerr := l.mounter.Unmount(target); uerr != nil {
+			warnf("Could not unmount '%s' after failed mount: %v", target, uerr)
+			return
+		}
+		_ = os.Remove(target)
+	}()
+
+	subdir := volumeSubdir(resdef.Props)
+	mnt := l.reportedMountPath(req.Name, subdir)
+	if _, err = os.Stat(mnt); os.IsNotExist(err) { // check for remount
+		if err = l.mounter.MakeDir(mnt); err != nil {
+			return nil, err
+		}
+	}
+
+	// a read-only file system can not be grown
+	if !params.ReadOnly && params.AutoResize {
+		if err = l.resizeFS(source, target, fstype); err != nil {
+			return nil, err
+		}
+	}
+	if params.QuotaKiB > 0 && !params.ReadOnly {
+		if err = l.setQuota(target, mnt, params.QuotaKiB); err != nil {
+			return nil, err
 		}
 	}
-	return url.Parse(scheme + "://" + host)
+
+	state.mounts++
+	state.subdir = subdir
+	metrics.addMounted(1)
+	return &volume.MountResponse{Mountpoint: mnt}, nil
 }
 
-func (l *LinstorDriver) newClient() (*client.Client, error) {
-	config := new(LinstorConfig)
-	if err := l.loadConfig(config); err != nil {
-		return nil, err
-	}
+// sysBlock is where the kernel lists block devices, with their queue settings
+const sysBlock = "/sys/block"
 
-	err := envconfig.InitWithOptions(config, envconfig.Options{Prefix: "LS", AllOptional: true})
+// schedulerName matches the names of I/O schedulers
+var schedulerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// setScheduler sets the I/O scheduler of source and the devices below it, e.g. the disk under a LUKS mapping. Devices
+// without a scheduler of their own, like DRBD, are skipped, nothing is reverted on unmount.
+func setScheduler(source, scheduler string) {
+	dev, err := filepath.EvalSymlinks(source)
 	if err != nil {
-		return nil, err
+		warnf("Not setting I/O scheduler of '%s': %v", source, err)
+		return
+	}
+	set := 0
+	var walk func(name string)
+	walk = func(name string) {
+		path := filepath.Join(sysBlock, name, "queue", "scheduler")
+		if err := ioutil.WriteFile(path, []byte(scheduler), 0644); err == nil {
+			debugf("Set I/O scheduler of '%s' to %s", name, scheduler)
+			set++
+		} else {
+			debugf("Could not set I/O scheduler of '%s': %v", name, err)
+		}
+		slaves, _ := ioutil.ReadDir(filepath.Join(sysBlock, name, "slaves"))
+		for _, slave := range slaves {
+			walk(slave.Name())
+		}
 	}
+	walk(filepath.Base(dev))
+	if set == 0 {
+		warnf("Could not set I/O scheduler %s on '%s' or any device below it", scheduler, dev)
+	}
+}
 
-	baseURL, err := l.newBaseURL(config.Controllers)
+// discardSupported reports whether the device source passes discards on, DRBD does so if its backing devices do
+func discardSupported(source string) (bool, error) {
+	dev, err := filepath.EvalSymlinks(source)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-
-	tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
-		CertFile:           config.CertFile,
-		KeyFile:            config.KeyFile,
-		CAFile:             config.CAFile,
-		InsecureSkipVerify: config.CAFile == "",
-		ExclusiveRootPools: true,
-	})
+	content, err := ioutil.ReadFile(filepath.Join(sysBlock, filepath.Base(dev), "queue", "discard_max_bytes"))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	max, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return max > 0, err
+}
 
-	return client.NewClient(
-		client.BaseURL(baseURL),
-		client.BasicAuth(&client.BasicAuthCfg{Username: config.Username, Password: config.Password}),
-		client.HTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}),
-	)
+// detectFS asks blkid for the file system on source, defaultFS is taken if there is none
+func (l *LinstorDriver) detectFS(source, defaultFS string) (string, error) {
+	out, err := l.mounter.Exec.Run("blkid", "-p", "-s", "TYPE", "-o", "value", source)
+	if fstype := strings.TrimSpace(string(out)); err == nil && fstype != "" {
+		warnf("Volume on '%s' does not say which file system it has, mounting the detected %s", source, fstype)
+		return fstype, nil
+	}
+	if defaultFS == "" {
+		return "", fmt.Errorf("no file system detected on '%s' and LS_DEFAULT_FS is not set", source)
+	}
+	warnf("Volume on '%s' does not say which file system it has and none was detected, mounting %s", source, defaultFS)
+	return defaultFS, nil
 }
 
-func (l *LinstorDriver) newParams(name string, options map[string]string) (*LinstorParams, error) {
-	params := new(LinstorParams)
-	if err := l.loadConfig(params); err != nil {
-		return nil, err
+// quotaProject is the XFS project of the directory handed to containers, every volume has its own file system
+const quotaProject = 1
+
+// setQuota limits the directory dir of the XFS file system mounted on target to quota KiB
+func (l *LinstorDriver) setQuota(target, dir string, quota uint64) error {
+	for _, cmd := range []string{
+		fmt.Sprintf("project -s -p %s %d", dir, quotaProject),
+		fmt.Sprintf("limit -p bhard=%dk %d", quota, quotaProject),
+	} {
+		debugf("Running xfs_quota '%s' on '%s'", cmd, target)
+		if out, err := l.mounter.Exec.Run("xfs_quota", "-x", "-c", cmd, target); err != nil {
+			return fmt.Errorf("Could not set quota on '%s': %v: %s", dir, err, out)
+		}
 	}
-	if options != nil {
-		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{Result: params, WeaklyTypedInput: true, DecodeHook: mapstructure.StringToSliceHookFunc(" ")})
+	return nil
+}
+
+// mountOptData is what mount-opts templates can refer to
+type mountOptData struct {
+	Name string
+}
+
+// expandMountOpts fills in templates like "subvol={{.Name}}", options without template are taken as they are
+func expandMountOpts(opts []string, name string) ([]string, error) {
+	expanded := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		if !strings.Contains(opt, "{{") {
+			expanded = append(expanded, opt)
+			continue
+		}
+		tmpl, err := template.New("mount-opts").Parse(opt)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Could not parse mount-opts '%s': %w", opt, err)
 		}
-		if err = decoder.Decode(options); err != nil {
-			return nil, err
+		var b strings.Builder
+		if err := tmpl.Execute(&b, mountOptData{Name: name}); err != nil {
+			return nil, fmt.Errorf("Could not expand mount-opts '%s': %w", opt, err)
 		}
+		expanded = append(expanded, b.String())
 	}
-	// size conversion
-	if params.Size == "" { params.Size = "100MB" }
-	u := unit.MustNewUnit(unit.DefaultUnits)
-	v, err := u.ValueFromString(params.Size)
-	if err != nil { return nil, fmt.Errorf("Could not convert '%s': %v", params.Size, err) }
-	bytes := v.Value; lower := 4 * unit.M
-	if bytes < lower { bytes = lower }
-	params.SizeKiB = uint64(bytes / unit.K)
-	if params.FS == "" { params.FS = "ext4" }
-	if params.Replicas == 0 { params.Replicas = 2 }
-	return params, nil
+	return expanded, nil
 }
 
-func (l *LinstorDriver) Create(req *volume.CreateRequest) error {
-	params, err := l.newParams(req.Name, req.Options)
-	if err != nil { return err }
-	c, err := l.newClient()
-	if err != nil { return err }
-	ctx := context.Background()
-
-	// volume definition (size)
-	if err := c.ResourceDefinitions.CreateVolumeDefinition(ctx, req.Name, client.VolumeDefinitionCreate{VolumeDefinition: client.VolumeDefinition{SizeKib: params.SizeKiB}}); err != nil {
+// mountRaw bind mounts the device source on the file target, containers get the block device itself
+func (l *LinstorDriver) mountRaw(source, target string, readOnly bool) error {
+	if err := l.mounter.MakeFile(target); err != nil {
 		return err
 	}
-
-	// build props
-	props := map[string]string{pluginFlagKey: pluginFlagValue, pluginFSTypeKey: params.FS, "FileSystem/MkfsParams": params.FSOpts}
-	addProp := func(key, val string) { if val != "" { props["drbdOptions/"+key] = val } }
-	addProp("protocol", params.Protocol)
-	addProp("connect-int", params.ConnectInterval)
-	addProp("ping-int", params.PingInterval)
-	addProp("ping-timeout", params.PingTimeout)
-	addProp("resync-rate", params.ResyncRate)
-	addProp("al-extents", params.ALExtents)
-	addProp("max-buffers", params.MaxBuffers)
-	addProp("max-epoch-size", params.MaxEpochSize)
-	addProp("handler-split-brain", params.HandlerSplitBrain)
-	addProp("handler-pri-on-incon-degr", params.HandlerPriOnInconDegr)
-	addProp("primary-set-on", params.PrimarySetOn)
-
-	// resource definition
-	if err := c.ResourceDefinitions.Create(ctx, client.ResourceDefinitionCreate{ResourceDefinition: client.ResourceDefinition{Name: req.Name, Props: props}}); err != nil {
-		c.ResourceDefinitions.DeleteVolumeDefinition(ctx, req.Name, 0)
-		return err
+	opts := []string{"bind"}
+	if readOnly {
+		opts = append(opts, "ro")
 	}
-
-	// place resources
-	if err := l.resourcesCreate(ctx, c, req, params); err != nil {
-		c.ResourceDefinitions.Delete(ctx, req.Name)
-		c.ResourceDefinitions.DeleteVolumeDefinition(ctx, req.Name, 0)
+	debugf("Bind mounting '%s' on '%s' with options %v", source, target, opts)
+	if err := l.mounter.Mount(source, target, "", opts); err != nil {
+		_ = os.Remove(target)
 		return err
 	}
 	return nil
 }
 
-// resourcesCreate places diskfull or diskless based on params
-func (l *LinstorDriver) resourcesCreate(ctx context.Context, c *client.Client, req *volume.CreateRequest, params *LinstorParams) error {
-	err := c.ResourceDefinitions.Create(ctx, client.ResourceDefinitionCreate{ /* noop: skip */ }) // placeholder
-	// original logic here
-	if len(params.Nodes) == 0 {
-		return c.Resources.Autoplace(ctx, req.Name, client.AutoPlaceRequest{
-			DisklessOnRemaining: params.DisklessOnRemaining,
-			SelectFilter: client.AutoSelectFilter{PlaceCount: params.Replicas, StoragePool: params.StoragePool, NotPlaceWithRscRegex: params.DoNotPlaceWithRegex, ReplicasOnSame: params.ReplicasOnSame, ReplicasOnDifferent: params.ReplicasOnDifferent},
-		})
-	}
-	for _, node := range params.Nodes {
-		if err := c.Resources.Create(ctx, l.toDiskfullCreate(req.Name, node, params)); err != nil {
-			return err
+// waitDevice waits until source exists as a block device
+func waitDevice(source string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := os.Stat(source)
+		if err == nil && info.Mode()&os.ModeDevice != 0 {
+			return nil
 		}
+		if err == nil {
+			err = errors.New("not a block device")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device '%s' did not show up within %v: %w", source, timeout, err)
+		}
+		debugf("Waiting for device '%s': %v", source, err)
+		time.Sleep(devicePollInterval)
 	}
-	return err
-}
-
-func (l *LinstorDriver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
-	c, err := l.newClient()
-	if err != nil {
-		return nil, err
-	}
-	ctx := context.Background()
-	resourceDef, err := c.ResourceDefinitions.Get(ctx, req.Name)
-	if err != nil {
-		return nil, err
-	}
-	if resourceDef.Props[pluginFlagKey] != pluginFlagValue {
-		return nil, fmt.Errorf("Volume '%s' is not managed by this plugin", req.Name)
-	}
-	vol := &volume.Volume{
-		Name:       resourceDef.Name,
-		Mountpoint: l.mountPoint(resourceDef.Name),
-	}
-	return &volume.GetResponse{vol}, nil
 }
 
-func (l *LinstorDriver) List() (*volume.ListResponse, error) {
-	c, err := l.newClient()
-	if err != nil {
-		return nil, err
-	}
-	ctx := context.Background()
-	resourceDefs, err := c.ResourceDefinitions.GetAll(ctx)
-	if err != nil {
-		return nil, err
-	}
-	vols := []*volume.Volume{}
-	for _, resourceDef := range resourceDefs {
-		if resourceDef.Props[pluginFlagKey] != pluginFlagValue {
-			continue
+// waitPrimary waits until source can be opened for writing. For DRBD that is exactly when it can be promoted,
+// auto-promote makes it primary on open and secondary again on close.
+func waitPrimary(source string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(source, os.O_RDWR, 0)
+		if err == nil {
+			return f.Close()
 		}
-		vols = append(vols, &volume.Volume{
-			Name:       resourceDef.Name,
-			Mountpoint: l.mountPoint(resourceDef.Name),
-		})
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device '%s' could not be promoted to primary within %v: %w", source, timeout, err)
+		}
+		debugf("Waiting for '%s' to become promotable: %v", source, err)
+		time.Sleep(devicePollInterval)
 	}
-	return &volume.ListResponse{Volumes: vols}, nil
 }
 
-func (l *LinstorDriver) Remove(req *volume.RemoveRequest) error {
-	return l.remove(req.Name, true)
-}
+// waitPromotable waits until the volume on this node can become primary according to LINSTOR: its own disk is
+// UpToDate or, for diskless access, the disk of a peer is. The checks back off up to promotablePollMax.
+func (l *LinstorDriver) waitPromotable(c *client.Client, name string, volNr int, timeout time.Duration) error {
+	// not bound by the request timeout, like waitPrimary
+	ctx, cancel := context.WithTimeout(l.ctx, timeout)
+	defer cancel()
 
-func (l *LinstorDriver) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
-	return &volume.PathResponse{Mountpoint: l.mountPoint(req.Name)}, nil
+	debugf("Waiting up to %v for volume '%s' to become promotable on node '%s'", timeout, name, l.node)
+	var states []string
+	for interval := devicePollInterval; ; interval *= 2 {
+		resources, err := c.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{name}})
+		if err == nil {
+			states = states[:0]
+			local, peerUpToDate := "", false
+			for _, r := range resources {
+				vol, ok := findVolume(r.Volumes, volNr)
+				if !ok {
+					continue
+				}
+				states = append(states, r.NodeName+": "+vol.State.DiskState)
+				if r.NodeName == l.node {
+					local = vol.State.DiskState
+				} else if vol.State.DiskState == "UpToDate" {
+					peerUpToDate = true
+				}
+			}
+			if local == "UpToDate" || (local == "Diskless" && peerUpToDate) {
+				return nil
+			}
+		} else if ctx.Err() == nil {
+			debugf("Could not check state of volume '%s': %v", name, err)
+		}
+		if interval > promotablePollMax {
+			interval = promotablePollMax
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Volume '%s' did not become promotable on node '%s' within %v (%s)", name, l.node, timeout, strings.Join(states, ", "))
+		case <-time.After(interval):
+		}
+	}
 }
 
-func (l *LinstorDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, error) {
-	params, err := l.newParams(req.Name, nil)
-	if err != nil {
-		return nil, err
+// waitReady waits up to LS_WAIT_READY until a replica of the volume is UpToDate
+func (l *LinstorDriver) waitReady(name string, volNr int) error {
+	config, err := l.newConfig()
+	if err != nil || config.WaitReady <= 0 {
+		return err
 	}
 	c, err := l.newClient()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ctx := context.Background()
-	if _, err = c.Resources.Get(ctx, req.Name, l.node); err == client.NotFoundError {
-		err = c.Resources.Create(ctx, l.toDisklessCreate(req.Name, l.node, params))
-		if err != nil {
-			return nil, err
+	ctx, cancel := context.WithTimeout(l.ctx, config.WaitReady)
+	defer cancel()
+
+	debugf("Waiting up to %v for a replica of volume '%s' to become UpToDate", config.WaitReady, name)
+	var states []string
+	for {
+		resources, err := c.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{name}})
+		if err == nil {
+			states = states[:0]
+			for _, r := range resources {
+				vol, ok := findVolume(r.Volumes, volNr)
+				if !ok || vol.ProviderKind == client.DISKLESS {
+					continue
+				}
+				if vol.State.DiskState == "UpToDate" {
+					return nil
+				}
+				states = append(states, r.NodeName+": "+vol.State.DiskState)
+			}
+		} else if ctx.Err() == nil {
+			debugf("Could not check sync state of volume '%s': %v", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Volume '%s' was created, but none of its replicas became UpToDate within %v (%s)", name, config.WaitReady, strings.Join(states, ", "))
+		case <-time.After(readyPollInterval):
 		}
 	}
-	// properties are not merged, so we have to query the resdef
-	// as we set the property there
-	resdef, err := c.ResourceDefinitions.Get(ctx, req.Name)
-	if err != nil {
-		return nil, err
-	}
-	fstype, ok := resdef.Props[pluginFSTypeKey]
-	if !ok {
-		return nil, fmt.Errorf("Volume '%s' did not contain a file system key", req.Name)
-	}
-	vol, err := c.Resources.GetVolume(ctx, req.Name, l.node, 0)
-	if err != nil {
-		return nil, err
-	}
-	source := vol.DevicePath
-	inUse, err := l.mounter.DeviceOpened(source)
-	if err != nil {
-		return nil, err
-	}
-	if inUse {
-		return nil, fmt.Errorf("unable to get exclusive open on %s", source)
-	}
-	target := l.realMountPath(req.Name)
-	if err = l.mounter.MakeDir(target); err != nil {
-		return nil, err
-	}
-	err = l.mounter.Mount(source, target, fstype, params.MountOpts)
-	if err != nil {
-		return nil, err
-	}
+}
 
-	mnt := l.reportedMountPath(req.Name)
-	if _, err = os.Stat(mnt); os.IsNotExist(err) { // check for remount
-		if err = l.mounter.MakeDir(mnt); err != nil {
-			return nil, err
+// resizeFS grows the mounted file system to the size of its device
+func (l *LinstorDriver) resizeFS(source, target, fstype string) error {
+	switch fstype {
+	case "ext3", "ext4", "xfs":
+		// xfs_growfs works on the mounted target, which ResizeFs takes care of
+		needResize, err := l.resizer.NeedResize(source, target)
+		if err != nil || !needResize {
+			return err
 		}
+		debugf("Resizing %s file system on '%s'", fstype, source)
+		_, err = l.resizer.Resize(source, target)
+		return err
+	case "btrfs":
+		// not covered by ResizeFs, growing to max is a noop if there is nothing to grow
+		if out, err := l.mounter.Exec.Run("btrfs", "filesystem", "resize", "max", target); err != nil {
+			return fmt.Errorf("Could not resize btrfs on '%s': %v: %s", target, err, out)
+		}
+		return nil
+	default:
+		warnf("Not resizing '%s', resizing %s is not supported", source, fstype)
+		return nil
 	}
+}
 
-	needResize, err := l.resizer.NeedResize(source, target)
+func (l *LinstorDriver) Unmount(req *volume.UnmountRequest) (err error) {
+	defer metrics.observe("unmount", time.Now(), &err)
+	defer logError("Unmount", req.Name, &err)
+	defer l.notify("unmount", req.Name, &err)
+	done, err := l.begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if needResize {
-		if _, err = l.resizer.Resize(source, target); err != nil {
-			return nil, err
-		}
+	defer done()
+	state := l.lockVolume(req.Name)
+	defer l.unlockVolume(req.Name, state)
+	defer l.saveMounts(req.Name, state)
+	// only the last user actually unmounts
+	if state.mounts > 1 {
+		state.mounts--
+		debugf("Volume '%s' is still used on node '%s', %d active mounts", req.Name, l.node, state.mounts)
+		return nil
 	}
 
-	return &volume.MountResponse{Mountpoint: mnt}, nil
-}
-
-func (l *LinstorDriver) Unmount(req *volume.UnmountRequest) error {
 	target := l.realMountPath(req.Name)
 	notMounted, err := l.mounter.IsNotMountPoint(target)
-	if err != nil || notMounted {
+	if err != nil {
 		return err
 	}
-	if err = l.mounter.Unmount(target); err != nil {
-		return err
+	if !notMounted {
+		debugf("Unmounting '%s'", target)
+		if err = l.mounter.Unmount(target); err != nil {
+			return err
+		}
+	}
+	// the mount is only gone once unmounted, after a failure Docker can still retry
+	if state.mounts == 1 {
+		metrics.addMounted(-1)
+	}
+	state.mounts = 0
+	if notMounted {
+		return nil
 	}
 
 	// try to remove now unused dir
@@ -368,8 +2480,9 @@ func (l *LinstorDriver) Unmount(req *volume.UnmountRequest) error {
 	diskless, err := l.isDiskless(req.Name)
 	// in this case we don't really care about the error, just log it, and keep the diskless assignment.
 	if err != nil {
-		log.Println(err)
+		warnf("Could not check if volume '%s' is diskless on node '%s': %v", req.Name, l.node, err)
 	} else if diskless {
+		debugf("Removing diskless resource of volume '%s' from node '%s'", req.Name, l.node)
 		return l.remove(req.Name, false)
 	}
 
@@ -383,31 +2496,96 @@ func (l *LinstorDriver) Capabilities() *volume.CapabilitiesResponse {
 }
 
 func (l *LinstorDriver) loadConfig(result interface{}) error {
-	if _, err := os.Stat(l.config); os.IsNotExist(err) {
-		return nil
-	}
-	file, err := ini.InsensitiveLoad(l.config)
-	if err != nil {
-		return err
+	if _, err := os.Stat(l.config); err == nil {
+		file, err := ini.InsensitiveLoad(l.config)
+		if err != nil {
+			return fmt.Errorf("Could not load config '%s': %w", l.config, err)
+		}
+		section := file.Section("global")
+		l.warnUnknownKeys(section.KeyStrings(), l.config)
+		if err := section.MapTo(result); err != nil {
+			return fmt.Errorf("Could not map section [global] of config '%s': %w", l.config, err)
+		}
 	}
-	return file.Section("global").MapTo(result)
+	return l.loadConfigDir(result)
 }
 
 func (l *LinstorDriver) realMountPath(name string) string {
 	return filepath.Join(l.root, name)
 }
 
-func (l *LinstorDriver) reportedMountPath(name string) string {
-	return filepath.Join(l.realMountPath(name), datadir)
+func (l *LinstorDriver) reportedMountPath(name, subdir string) string {
+	return filepath.Join(l.realMountPath(name), subdir)
+}
+
+// volumeSubdir is the directory of the volume handed to containers, volumes from before it was configurable use datadir
+func volumeSubdir(props map[string]string) string {
+	if subdir, ok := props[pluginSubdirKey]; ok {
+		return subdir
+	}
+	return datadir
+}
+
+// volumeNumber returns the persisted volume-number of a volume, 0 unless it was given on create
+func volumeNumber(props map[string]string) int {
+	volNr, err := strconv.Atoi(props[pluginOptionPrefix+"volume-number"])
+	if err != nil {
+		return 0
+	}
+	return volNr
+}
+
+func findVolume(vols []client.Volume, volNr int) (client.Volume, bool) {
+	for _, vol := range vols {
+		if int(vol.VolumeNumber) == volNr {
+			return vol, true
+		}
+	}
+	return client.Volume{}, false
+}
+
+// getVolumeDefinition returns volume volNr of resource definition name. GetVolumeDefinition of golinstor requests a
+// path without the slash before the number, so all volume definitions are listed instead.
+func getVolumeDefinition(ctx context.Context, c *client.Client, name string, volNr int) (client.VolumeDefinition, error) {
+	voldefs, err := c.ResourceDefinitions.GetVolumeDefinitions(ctx, name)
+	if err != nil {
+		return client.VolumeDefinition{}, err
+	}
+	for _, voldef := range voldefs {
+		if int(voldef.VolumeNumber) == volNr {
+			return voldef, nil
+		}
+	}
+	return client.VolumeDefinition{}, client.NotFoundError
 }
 
-func (l *LinstorDriver) mountPoint(name string) string {
-	path := l.realMountPath(name)
-	notMounted, err := l.mounter.IsNotMountPoint(path)
-	if err != nil || notMounted {
+func (l *LinstorDriver) mountPoint(name, subdir string) string {
+	if mounted, _ := l.mounted(name); !mounted {
 		return ""
 	}
-	return l.reportedMountPath(name)
+	return l.reportedMountPath(name, subdir)
+}
+
+// mounted reports whether the volume is mounted on this node, a mount path that does not exist is not an error
+func (l *LinstorDriver) mounted(name string) (bool, error) {
+	notMounted, err := l.mounter.IsNotMountPoint(l.realMountPath(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !notMounted, nil
+}
+
+// mountStatus is the mounted entry of the volume status, "unknown" if the mount path could not be checked
+func (l *LinstorDriver) mountStatus(name string) interface{} {
+	mounted, err := l.mounted(name)
+	if err != nil {
+		warnf("Could not check whether volume '%s' is mounted: %v", name, err)
+		return "unknown"
+	}
+	return mounted
 }
 
 func (l *LinstorDriver) toDiskfullCreate(name, node string, params *LinstorParams) client.ResourceCreate {
@@ -421,22 +2599,62 @@ func (l *LinstorDriver) toDiskfullCreate(name, node string, params *LinstorParam
 			NodeName: node,
 			Props:    props,
 		},
+		LayerList: params.Layers,
+	}
+}
+
+// toLayerData converts a layer stack into the resource definition layer data
+func toLayerData(layers []client.LayerType) []client.ResourceDefinitionLayer {
+	var data []client.ResourceDefinitionLayer
+	for _, layer := range layers {
+		data = append(data, client.ResourceDefinitionLayer{Type: layer})
 	}
+	return data
 }
 
+// resource flags golinstor has no constants for
+const (
+	flagNvmeInitiator = "NVME_INITIATOR"
+	flagTieBreaker    = "TIE_BREAKER"
+)
+
 func (l *LinstorDriver) toDisklessCreate(name, node string, params *LinstorParams) client.ResourceCreate {
 	props := make(map[string]string)
 	if params.DisklessStoragePool != "" {
 		props[linstor.KeyStorPoolName] = params.DisklessStoragePool
 	}
+	flags := []string{linstor.FlagDiskless}
+	// without DRBD the diskless access is an NVMe-oF initiator
+	if isNVMeOnly(params.Layers) {
+		flags = append(flags, flagNvmeInitiator)
+	} else if params.TieBreaker {
+		flags = append(flags, flagTieBreaker)
+	}
 	return client.ResourceCreate{
 		Resource: client.Resource{
 			Name:     name,
 			NodeName: node,
 			Props:    props,
-			Flags:    []string{linstor.FlagDiskless},
+			Flags:    flags,
 		},
+		LayerList: params.Layers,
+	}
+}
+
+// enterPassphrase unlocks the LUKS master key of the controller
+func (l *LinstorDriver) enterPassphrase(ctx context.Context, c *client.Client) error {
+	config, err := l.newConfig()
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase(config.LuksPassphrase, config.LuksPassphraseFile)
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return errors.New("Encryption requires LS_LUKS_PASSPHRASE or LS_LUKS_PASSPHRASE_FILE to be set")
 	}
+	return timeoutError("enter passphrase", c.Encryption.Enter(ctx, passphrase))
 }
 
 func (l *LinstorDriver) isDiskless(name string) (bool, error) {
@@ -445,21 +2663,60 @@ func (l *LinstorDriver) isDiskless(name string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	ctx := context.Background()
+	ctx, cancel := l.newContext()
+	defer cancel()
 
-	// view to get storage information as well
-	resources, err := c.Resources.GetResourceView(ctx, &lopt)
+	var resdef client.ResourceDefinition
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resdef, err = c.ResourceDefinitions.Get(ctx, name)
+		return err
+	})
 	if err != nil {
-		return false, err
+		return false, timeoutError("get resource definition", err)
 	}
-	if len(resources) != 1 {
-		return false, errors.New("Resource filter has to contain exactly one resource")
+	volNr := volumeNumber(resdef.Props)
+	if resdef.Props[pluginOptionPrefix+"keep-diskless"] == "true" {
+		return false, nil
 	}
-	if len(resources[0].Volumes) != 1 {
-		return false, errors.New("There has to be exactly one volume in the resource")
+	// diskless resources created in advance stay
+	for _, node := range strings.Fields(resdef.Props[pluginOptionPrefix+"diskless-nodes"]) {
+		if node == l.node {
+			return false, nil
+		}
 	}
 
-	return resources[0].Volumes[0].ProviderKind == client.DISKLESS, nil
+	// view to get storage information as well
+	var resources []client.ResourceWithVolumes
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resources, err = c.Resources.GetResourceView(ctx, &lopt)
+		return err
+	})
+	if err != nil {
+		return false, timeoutError("get resource view", err)
+	}
+	for _, r := range resources {
+		if r.Name != name || r.NodeName != l.node {
+			continue
+		}
+		// NVMe-oF initiators are the diskless access of volumes without DRBD
+		for _, flag := range r.Flags {
+			if flag == flagNvmeInitiator {
+				return true, nil
+			}
+		}
+		// only a resource without any local data is diskless, one without the mounted volume is left alone
+		if _, ok := findVolume(r.Volumes, volNr); !ok {
+			return false, nil
+		}
+		for _, vol := range r.Volumes {
+			if vol.ProviderKind != client.DISKLESS {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	// nothing to clean up
+	return false, nil
 }
 
 func (l *LinstorDriver) remove(name string, global bool) error {
@@ -467,22 +2724,90 @@ func (l *LinstorDriver) remove(name string, global bool) error {
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
 
 	if !global {
-		return c.Resources.Delete(ctx, name, l.node)
+		ctx, cancel := l.newContext()
+		defer cancel()
+		return timeoutError("delete resource", c.Resources.Delete(ctx, name, l.node))
 	}
 
 	// global
-	snaps, err := c.Resources.GetSnapshots(ctx, name)
+	config, err := l.newConfig()
 	if err != nil {
 		return err
 	}
+	timeout := config.RemoveTimeout
+	if timeout <= 0 {
+		timeout = config.RequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(l.ctx, timeout)
+	defer cancel()
+
+	var resources []client.Resource
+	err = l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resources, err = c.Resources.GetAll(ctx, name)
+		return err
+	})
+	if err != nil && err != client.NotFoundError {
+		return timeoutError("list resources", err)
+	}
+	var inUse []string
+	for _, r := range resources {
+		if r.State.InUse {
+			inUse = append(inUse, r.NodeName)
+		}
+	}
+	if len(inUse) > 0 && !config.ForceRemove {
+		return fmt.Errorf("Volume '%s' is in use on node(s) %s, set LS_FORCE_REMOVE to remove it anyway", name, strings.Join(inUse, ", "))
+	}
+	if config.ForceRemove {
+		for _, r := range resources {
+			debugf("Detaching volume '%s' from node '%s'", name, r.NodeName)
+			if err := c.Resources.Delete(ctx, name, r.NodeName); err != nil {
+				return l.removeBlocked(c, name, timeoutError("delete resource on node '"+r.NodeName+"'", err))
+			}
+		}
+	}
+
+	snaps, err := c.Resources.GetSnapshots(ctx, name)
+	if err != nil {
+		return timeoutError("list snapshots", err)
+	}
 	for _, snap := range snaps {
 		err = c.Resources.DeleteSnapshot(ctx, name, snap.Name)
 		if err != nil {
-			return err
+			return l.removeBlocked(c, name, timeoutError("delete snapshot", err))
+		}
+	}
+	if err := c.ResourceDefinitions.Delete(ctx, name); err != nil {
+		return l.removeBlocked(c, name, timeoutError("delete resource definition", err))
+	}
+	return nil
+}
+
+// removeBlocked wraps a failed removal with the resources and snapshots that are still left
+func (l *LinstorDriver) removeBlocked(c *client.Client, name string, err error) error {
+	// the removal context might be expired already
+	ctx, cancel := context.WithTimeout(l.ctx, controllerProbeTimeout)
+	defer cancel()
+
+	var blockers []string
+	if resources, rerr := c.Resources.GetAll(ctx, name); rerr == nil {
+		for _, r := range resources {
+			blocker := "resource on '" + r.NodeName + "'"
+			if r.State.InUse {
+				blocker += " (in use)"
+			}
+			blockers = append(blockers, blocker)
+		}
+	}
+	if snaps, serr := c.Resources.GetSnapshots(ctx, name); serr == nil {
+		for _, snap := range snaps {
+			blockers = append(blockers, "snapshot '"+snap.Name+"'")
 		}
 	}
-	return c.ResourceDefinitions.Delete(ctx, name)
+	if len(blockers) == 0 {
+		return fmt.Errorf("Could not remove volume '%s': %w", name, err)
+	}
+	return fmt.Errorf("Could not remove volume '%s', blocked by %s: %w", name, strings.Join(blockers, ", "), err)
 }
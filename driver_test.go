@@ -0,0 +1,964 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestDrbdProps(t *testing.T) {
+	params := &LinstorParams{
+		Protocol:              "C",
+		ConnectInterval:       "10",
+		PingInterval:          "10",
+		PingTimeout:           "5",
+		MaxBuffers:            "8000",
+		MaxEpochSize:          "8000",
+		VerifyAlg:             "crc32c",
+		CsumsAlg:              "sha1",
+		DataIntegrityAlg:      "md5",
+		ResyncRate:            "100M",
+		ALExtents:             "6433",
+		Quorum:                "majority",
+		OnNoQuorum:            "io-error",
+		HandlerSplitBrain:     "/usr/lib/drbd/notify-split-brain.sh",
+		HandlerPriOnInconDegr: "/usr/lib/drbd/notify-pri-on-incon-degr.sh",
+	}
+	expected := map[string]string{
+		"DrbdOptions/Net/protocol":               "C",
+		"DrbdOptions/Net/connect-int":            "10",
+		"DrbdOptions/Net/ping-int":               "10",
+		"DrbdOptions/Net/ping-timeout":           "5",
+		"DrbdOptions/Net/max-buffers":            "8000",
+		"DrbdOptions/Net/max-epoch-size":         "8000",
+		"DrbdOptions/Net/verify-alg":             "crc32c",
+		"DrbdOptions/Net/csums-alg":              "sha1",
+		"DrbdOptions/Net/data-integrity-alg":     "md5",
+		"DrbdOptions/PeerDevice/resync-rate":     "100M",
+		"DrbdOptions/Disk/al-extents":            "6433",
+		"DrbdOptions/Disk/resync-after":          "other/0",
+		"DrbdOptions/Resource/quorum":            "majority",
+		"DrbdOptions/Resource/on-no-quorum":      "io-error",
+		"DrbdOptions/Handlers/split-brain":       "/usr/lib/drbd/notify-split-brain.sh",
+		"DrbdOptions/Handlers/pri-on-incon-degr": "/usr/lib/drbd/notify-pri-on-incon-degr.sh",
+	}
+	if props := drbdProps(params, "other/0"); !reflect.DeepEqual(props, expected) {
+		t.Errorf("expected %v, got %v", expected, props)
+	}
+
+	if props := drbdProps(&LinstorParams{}, ""); len(props) != 0 {
+		t.Errorf("expected no properties for unset options, got %v", props)
+	}
+}
+
+func TestUnmountFailureKeepsMount(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+	ctrl.setDevice("vol", "node-a", testDevice)
+	before := mountedVolumes()
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	d.mounter.unmountErr = errors.New("device is busy")
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}); err == nil {
+		t.Fatal("expected the unmount to fail")
+	}
+	if !d.mounter.mounted(d.realMountPath("vol")) {
+		t.Fatal("volume was unmounted")
+	}
+	if got := mountedVolumes() - before; got != 1 {
+		t.Errorf("expected the volume to still count as mounted, gauge changed by %d", got)
+	}
+
+	// Docker retries, the mount count must not have dropped to 0 before
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("retried unmount failed: %v", err)
+	}
+	if d.mounter.mounted(d.realMountPath("vol")) {
+		t.Error("volume is still mounted")
+	}
+	if got := mountedVolumes() - before; got != 0 {
+		t.Errorf("expected the gauge back where it was, changed by %d", got)
+	}
+	if len(d.volumes) != 0 {
+		t.Errorf("expected no volume states left, got %d", len(d.volumes))
+	}
+}
+
+func TestConcurrentMountUnmount(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+	ctrl.setDevice("vol", "node-a", testDevice)
+	before := mountedVolumes()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: id}); err != nil {
+				errs <- err
+				return
+			}
+			if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: id}); err != nil {
+				errs <- err
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if d.mounter.mounted(d.realMountPath("vol")) {
+		t.Error("volume is still mounted after all containers unmounted it")
+	}
+	if got := mountedVolumes() - before; got != 0 {
+		t.Errorf("expected the gauge back where it was, changed by %d", got)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.volumes) != 0 {
+		t.Errorf("expected no volume states left, got %d", len(d.volumes))
+	}
+}
+
+func TestConcurrentUnmountOfDifferentVolumes(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+	names := []string{"vol0", "vol1", "vol2", "vol3"}
+	for _, name := range names {
+		ctrl.addVolume(name, nil, "node-a", "node-b")
+		ctrl.setDevice(name, "node-a", testDevice)
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "c1"}); err != nil {
+			t.Fatalf("mount of %s failed: %v", name, err)
+		}
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "c2"}); err != nil {
+			t.Fatalf("second mount of %s failed: %v", name, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		for _, id := range []string{"c1", "c2"} {
+			wg.Add(1)
+			go func(name, id string) {
+				defer wg.Done()
+				if err := d.Unmount(&volume.UnmountRequest{Name: name, ID: id}); err != nil {
+					t.Errorf("unmount of %s failed: %v", name, err)
+				}
+			}(name, id)
+		}
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		if d.mounter.mounted(d.realMountPath(name)) {
+			t.Errorf("%s is still mounted", name)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.volumes) != 0 {
+		t.Errorf("expected no volume states left, got %d", len(d.volumes))
+	}
+}
+
+func TestCreateTransport(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+
+	for _, req := range []*volume.CreateRequest{
+		{Name: "vol", Options: map[string]string{"transport": "rdma"}},
+		{Name: "copy", Options: map[string]string{"transport": "rdma", "snapshot-of": "vol"}},
+		{Name: "restored", Options: map[string]string{"transport": "rdma", "restore-from": "vol/copy"}},
+	} {
+		if err := d.Create(req); err != nil {
+			t.Fatalf("create of %s failed: %v", req.Name, err)
+		}
+		resdef, transport, ok := ctrl.resdef(req.Name)
+		if !ok {
+			t.Fatalf("resource definition %s was not created", req.Name)
+		}
+		if transport != "RDMA" {
+			t.Errorf("expected transport type RDMA for %s, got '%s'", req.Name, transport)
+		}
+		if val, ok := resdef.Props["DrbdOptions/Net/transport"]; ok {
+			t.Errorf("expected no transport property on %s, got '%s'", req.Name, val)
+		}
+	}
+}
+
+func TestCreatePeerSlots(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"peer-slots": "3"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	resdef, _, _ := ctrl.resdef("vol")
+	if slots := resdef.Props["DrbdOptions/PeerSlotsNewResource"]; slots != "3" {
+		t.Errorf("expected 3 peer slots in the created resource definition, got '%s'", slots)
+	}
+}
+
+func TestMountNVMeSkipsWaitPromotable(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a")
+	ctrl.setLayers("vol", client.NVME, client.STORAGE)
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl, "wait-promotable = 5s")
+
+	start := time.Now()
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("expected the mount not to wait for NVMe-oF volumes to become promotable, it took %v", waited)
+	}
+}
+
+func TestCreateNVMeSkipsWaitReady(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl, "wait-ready = 5s")
+
+	start := time.Now()
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"nvme": "true", "replicas": "1"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("expected create not to wait for NVMe-oF volumes to sync, it took %v", waited)
+	}
+}
+
+func TestCreateWaitReadyTimeout(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl, "wait-ready = 1s")
+
+	// the replicas never finish their initial sync
+	ctrl.setDiskState("Inconsistent")
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"snapshot-schedule": "0 * * * *"}})
+	if err == nil || !strings.Contains(err.Error(), "became UpToDate") {
+		t.Fatalf("expected create to time out waiting for the volume, got: %v", err)
+	}
+	if objects := ctrl.objects(); len(objects) != 0 {
+		t.Errorf("expected the volume to be rolled back, got %v", objects)
+	}
+	d.jobs.mu.Lock()
+	defer d.jobs.mu.Unlock()
+	if len(d.jobs.cancel) != 0 {
+		t.Errorf("expected no snapshot schedule for the removed volume, got %d", len(d.jobs.cancel))
+	}
+}
+
+func TestNewParamsIgnoresDerivedFields(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	params, err := d.newParams("vol", map[string]string{
+		"restorevolume":   "other",
+		"restoresnapshot": "snap",
+		"sizekib":         "1",
+		"layers":          "nvme",
+		"props":           "x",
+		"volumeskib":      "1",
+		"quotakib":        "1",
+	})
+	if err != nil {
+		t.Fatalf("newParams failed: %v", err)
+	}
+	if params.RestoreVolume != "" || params.RestoreSnapshot != "" {
+		t.Errorf("expected no restore source, got '%s/%s'", params.RestoreVolume, params.RestoreSnapshot)
+	}
+	if params.SizeKiB == 1 {
+		t.Error("expected the size not to be set via 'sizekib'")
+	}
+	if len(params.Layers) != 0 {
+		t.Errorf("expected no layers, got %v", params.Layers)
+	}
+	if len(params.Props) != 0 {
+		t.Errorf("expected no props, got %v", params.Props)
+	}
+	if len(params.VolumesKiB) != 0 || params.QuotaKiB != 0 {
+		t.Errorf("expected no volume sizes and no quota, got %v and %d", params.VolumesKiB, params.QuotaKiB)
+	}
+}
+
+func TestOptionHook(t *testing.T) {
+	for _, tc := range []struct {
+		to       reflect.Kind
+		data     interface{}
+		expected interface{}
+	}{
+		{reflect.Slice, "a  b\tc ", []string{"a", "b", "c"}},
+		{reflect.Slice, "", []string{}},
+		{reflect.Bool, "yes", true},
+		{reflect.Bool, " ON ", true},
+		{reflect.Bool, "no", false},
+		{reflect.Bool, "Off", false},
+		{reflect.Bool, " True ", "true"},
+		{reflect.Int32, " 3 ", "3"},
+		{reflect.Uint64, "4096\n", "4096"},
+		{reflect.String, " keep ", " keep "},
+		{reflect.Bool, true, true},
+	} {
+		got, err := optionHook(reflect.ValueOf(tc.data).Kind(), tc.to, tc.data)
+		if err != nil {
+			t.Errorf("%v %q: unexpected error: %v", tc.to, tc.data, err)
+			continue
+		}
+		// strings.Fields returns an empty, non-nil slice
+		if s, ok := got.([]string); ok && len(s) == 0 {
+			got = []string{}
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v %q: expected %#v, got %#v", tc.to, tc.data, tc.expected, got)
+		}
+	}
+}
+
+func TestNewParamsTypedOptions(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	for _, tc := range []struct {
+		option, value string
+		field         string
+		expected      interface{}
+	}{
+		{"nodes", "node-a  node-b", "Nodes", []string{"node-a", "node-b"}},
+		{"diskless-nodes", " node-c ", "DisklessNodes", []string{"node-c"}},
+		{"replicas-on-different", "Aux/rack Aux/room", "ReplicasOnDifferent", []string{"Aux/rack", "Aux/room"}},
+		{"replicas-on-same", "Aux/site", "ReplicasOnSame", []string{"Aux/site"}},
+		{"mount-opts", "noatime nodiratime", "MountOpts", []string{"noatime", "nodiratime"}},
+		{"volumes", "1GiB 2GiB", "Volumes", []string{"1GiB", "2GiB"}},
+		{"migrate-to", "node-a,node-b node-c", "MigrateTo", []string{"node-a", "node-b", "node-c"}},
+		{"layer-list", "drbd storage", "LayerList", []string{"drbd", "storage"}},
+		{"mkfs-force", "yes", "MkfsForce", true},
+		{"discard", "on", "Discard", true},
+		{"diskless-on-remaining", "1", "DisklessOnRemaining", true},
+		{"best-effort-placement", "true", "BestEffortPlacement", true},
+		{"encryption", "True", "Encryption", true},
+		{"nvme", " yes ", "NVMe", true},
+		{"readonly", "ON", "ReadOnly", true},
+		{"tiebreaker", "t", "TieBreaker", true},
+		{"auto-resize", "no", "AutoResize", false},
+		{"auto-resize", "off", "AutoResize", false},
+		{"auto-resize", "0", "AutoResize", false},
+		{"protect", "yes", "Protect", true},
+		{"keep-diskless", "yes", "KeepDiskless", true},
+		{"adopt", "yes", "Adopt", true},
+		{"rotate-passphrase", "yes", "RotatePassphrase", true},
+		{"dry-run", "yes", "DryRun", true},
+		{"gross-size", "yes", "GrossSize", true},
+		{"replicas", "3", "Replicas", int32(3)},
+		{"replicas", " 3 ", "Replicas", int32(3)},
+		{"fs-blocksize", "4096", "FSBlockSize", 4096},
+		{"volume-number", "2", "VolumeNumber", int32(2)},
+		{"peer-slots", "7", "PeerSlots", int32(7)},
+		{"port", "7100", "Port", int32(7100)},
+		{"minor", "1000", "Minor", int32(1000)},
+		{"snapshot-keep", "5", "SnapshotKeep", 5},
+	} {
+		params, err := d.newParams("vol", map[string]string{tc.option: tc.value})
+		if err != nil {
+			t.Errorf("%s=%q: newParams failed: %v", tc.option, tc.value, err)
+			continue
+		}
+		got := reflect.ValueOf(params).Elem().FieldByName(tc.field).Interface()
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s=%q: expected %#v, got %#v", tc.option, tc.value, tc.expected, got)
+		}
+	}
+
+	for _, options := range []map[string]string{
+		{"replicas": "three"},
+		{"readonly": "maybe"},
+		{"port": "-1"},
+		{"snapshot-keep": "5 6"},
+	} {
+		if _, err := d.newParams("vol", options); err == nil {
+			t.Errorf("expected %v to be rejected", options)
+		}
+	}
+}
+
+func TestNewParamsGrossSize(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	// 4MiB is the minimum, the metadata would eat into it
+	if _, err := d.newParams("vol", map[string]string{"size": "4MiB", "gross-size": "yes"}); err == nil {
+		t.Error("expected a gross size at the minimum to be rejected")
+	}
+	// the minimum of 4096KiB plus 36KiB and 4KiB for the one peer slot
+	if _, err := d.newParams("vol", map[string]string{"size": "4135KiB", "gross-size": "yes", "peer-slots": "1"}); err == nil {
+		t.Error("expected a gross size one KiB short to be rejected")
+	}
+	params, err := d.newParams("vol", map[string]string{"size": "4136KiB", "gross-size": "yes", "peer-slots": "1"})
+	if err != nil {
+		t.Fatalf("newParams failed: %v", err)
+	}
+	if params.SizeKiB != 4136 {
+		t.Errorf("expected the gross size to be kept as 4136KiB, got %d", params.SizeKiB)
+	}
+
+	if _, err := d.newParams("vol", map[string]string{"gross-size": "yes", "nvme": "yes"}); err == nil {
+		t.Error("expected gross-size to be rejected without a DRBD layer")
+	}
+}
+
+func TestNewBaseURL(t *testing.T) {
+	d := &LinstorDriver{}
+
+	for _, tc := range []struct {
+		controller string
+		expected   string
+	}{
+		{"", "http://localhost:3370"},
+		{"ctrl", "http://ctrl:3370"},
+		{"linstor://ctrl:8080", "http://ctrl:8080"},
+		{"linstor+ssl://ctrl", "https://ctrl:3371"},
+		{"linstor://[::1]", "http://[::1]:3370"},
+		{"linstor://::1", "http://[::1]:3370"},
+		{"linstor+ssl://[::1]", "https://[::1]:3371"},
+		{"https://[2001:db8::5]:3371", "https://[2001:db8::5]:3371"},
+		{"http://[2001:db8::5]:8080", "http://[2001:db8::5]:8080"},
+	} {
+		u, err := d.newBaseURL(tc.controller, 3370, 3371)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.controller, err)
+			continue
+		}
+		if u.String() != tc.expected {
+			t.Errorf("%q: expected '%s', got '%s'", tc.controller, tc.expected, u)
+		}
+	}
+}
+
+func TestNewBaseURLUnixSocket(t *testing.T) {
+	d := &LinstorDriver{}
+
+	u, err := d.newBaseURL("unix:///var/run/linstor.sock", 3370, 3371)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.Scheme != "unix" || u.Host != "" || u.Path != "/var/run/linstor.sock" {
+		t.Errorf("expected the socket /var/run/linstor.sock without host or port, got '%s'", u)
+	}
+
+	if _, err := d.newBaseURL("unix://", 3370, 3371); err == nil {
+		t.Error("expected a socket without path to be rejected")
+	}
+}
+
+func TestMountSubdirFailureUnmounts(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+	ctrl.setDevice("vol", "node-a", testDevice)
+	before := mountedVolumes()
+
+	d.mounter.makeDirErr[d.reportedMountPath("vol", datadir)] = errors.New("read-only file system")
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err == nil {
+		t.Fatal("expected the mount to fail")
+	}
+	if d.mounter.mounted(d.realMountPath("vol")) {
+		t.Error("expected the device to be unmounted again")
+	}
+	if got := mountedVolumes() - before; got != 0 {
+		t.Errorf("expected the gauge unchanged, changed by %d", got)
+	}
+
+	// nothing is left over that keeps Docker's retry from working
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("retried mount failed: %v", err)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+}
+
+func TestCreateResize(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"size": "200MiB"}}); err != nil {
+		t.Fatalf("resize failed: %v", err)
+	}
+	if calls := ctrl.calls("PUT /v1/resource-definitions/vol/volume-definitions/0"); len(calls) != 1 {
+		t.Errorf("expected the volume definition to be resized once, got %v", calls)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"size": "100MiB"}}); err == nil || !strings.Contains(err.Error(), "can not shrink") {
+		t.Errorf("expected shrinking the volume to fail, got: %v", err)
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	for _, tc := range []struct {
+		stage   string
+		method  string
+		path    string
+		options map[string]string
+	}{
+		{"resource definition", "POST", "/v1/resource-definitions", nil},
+		{"volume definition", "POST", "/v1/resource-definitions/vol/volume-definitions", nil},
+		{"autoplace", "POST", "/v1/resource-definitions/vol/autoplace", nil},
+		{"explicit placement", "POST", "/v1/resource-definitions/vol/resources/node-b", map[string]string{"nodes": "node-a node-b"}},
+		{"diskless placement", "POST", "/v1/resource-definitions/vol/resources/node-c", map[string]string{"nodes": "node-a node-b", "diskless-nodes": "node-c"}},
+		{"prewarm", "POST", "/v1/resource-definitions/vol/resources/node-c", map[string]string{"diskless-nodes": "node-c"}},
+		{"snapshot", "POST", "/v1/resource-definitions/src/snapshots", map[string]string{"snapshot-of": "src"}},
+		{"snapshot restore", "POST", "/v1/resource-definitions/src/snapshot-restore-resource/vol", map[string]string{"snapshot-of": "src"}},
+		{"restore", "POST", "/v1/resource-definitions/src/snapshot-restore-volume-definition/snap", map[string]string{"restore-from": "src/snap"}},
+	} {
+		t.Run(tc.stage, func(t *testing.T) {
+			ctrl := newFakeLinstor(t, "node-a", "node-b", "node-c")
+			d := newTestDriver(t, ctrl)
+			// sources of snapshots and restores are kept, snapshot-of leaves the snapshot "snap" of "src"
+			ctrl.addVolume("src", nil, "node-a", "node-b")
+			if err := d.Create(&volume.CreateRequest{Name: "snap", Options: map[string]string{"snapshot-of": "src"}}); err != nil {
+				t.Fatalf("create of the snapshot failed: %v", err)
+			}
+			before := ctrl.objects()
+
+			ctrl.fail(tc.method, tc.path, http.StatusInternalServerError, -1)
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: tc.options}); err == nil {
+				t.Fatal("expected create to fail")
+			}
+			if len(ctrl.calls(tc.method+" "+tc.path)) == 0 {
+				t.Fatalf("create failed before reaching %s", tc.path)
+			}
+			if objects := ctrl.objects(); !reflect.DeepEqual(objects, before) {
+				t.Errorf("expected the LINSTOR objects %v, got %v", before, objects)
+			}
+		})
+	}
+}
+
+func TestCreateResourceGroupRefused(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.groups["rg"] = client.ResourceGroup{Name: "rg"}
+	d := newTestDriver(t, ctrl)
+
+	ctrl.fail(http.MethodPost, "/v1/resource-definitions/vol/autoplace", http.StatusInternalServerError, 1)
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"resource-group": "rg"}})
+	if err == nil || !strings.Contains(err.Error(), "with resource group 'rg'") || !strings.Contains(err.Error(), "injected failure") {
+		t.Errorf("expected the refused placement to name the resource group, got: %v", err)
+	}
+}
+
+func TestCreateExplicitNodes(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b", "node-c")
+	d := newTestDriver(t, ctrl)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"nodes": "node-b node-c", "diskless-nodes": "node-a"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	var creates int
+	for _, call := range ctrl.calls("POST /v1/resource-definitions") {
+		if call == "POST /v1/resource-definitions" {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Errorf("expected the resource definition to be created once, got %d creates", creates)
+	}
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/autoplace"); len(calls) != 0 {
+		t.Errorf("expected no autoplace for explicit nodes, got %v", calls)
+	}
+	expected := []string{
+		"resource vol/node-a",
+		"resource vol/node-b",
+		"resource vol/node-c",
+		"resource-definition vol",
+		"volume-definition vol/0",
+	}
+	if objects := ctrl.objects(); !reflect.DeepEqual(objects, expected) {
+		t.Errorf("expected %v, got %v", expected, objects)
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", map[string]string{pluginOptionPrefix + "readonly": "true"}, "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	var ro bool
+	for _, opt := range d.mounter.options {
+		ro = ro || opt == "ro"
+	}
+	if !ro {
+		t.Errorf("expected the volume to be mounted with 'ro', got options %v", d.mounter.options)
+	}
+	// a read-only file system can not be grown
+	if len(d.commands.calls) != 0 {
+		t.Errorf("expected no resize, got %v", d.commands.calls)
+	}
+	// the data still has to reach the node
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/resources/node-a"); len(calls) != 1 {
+		t.Errorf("expected a diskless resource on node-a, got %v", calls)
+	}
+}
+
+func TestMountAutoResize(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("fixed", map[string]string{pluginOptionPrefix + "auto-resize": "false"}, "node-a", "node-b")
+	ctrl.setDevice("fixed", "node-a", testDevice)
+	ctrl.addVolume("grown", nil, "node-a", "node-b")
+	ctrl.setDevice("grown", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "fixed", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	if len(d.commands.calls) != 0 {
+		t.Errorf("expected the resizer not to run with auto-resize=false, got %v", d.commands.calls)
+	}
+
+	// resizing is the default
+	if _, err := d.Mount(&volume.MountRequest{Name: "grown", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	if len(d.commands.calls) == 0 {
+		t.Error("expected the resizer to run by default")
+	}
+}
+
+func TestMountExistingResource(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	// a resource is there, but nothing is mounted, e.g. after a restart of the plugin
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/resources"); len(calls) != 0 {
+		t.Errorf("expected the existing resource to be used, got %v", calls)
+	}
+	if !d.mounter.mounted(d.realMountPath("vol")) {
+		t.Error("volume is not mounted")
+	}
+}
+
+func TestMountResourceError(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.addVolume("vol", nil, "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	ctrl.fail("GET", "/v1/resource-definitions/vol/resources/node-a", http.StatusInternalServerError, -1)
+	_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"})
+	if err == nil {
+		t.Fatal("expected the mount to fail")
+	}
+	if !strings.Contains(err.Error(), "Could not get resource of volume 'vol' on node 'node-a'") {
+		t.Errorf("expected the error to name volume and node, got: %v", err)
+	}
+	// not mistaken for a missing resource
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/resources"); len(calls) != 0 {
+		t.Errorf("expected no diskless resource to be created, got %v", calls)
+	}
+}
+
+func TestRawVolume(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "none", "nodes": "node-b"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	resdef, _, _ := ctrl.resdef("vol")
+	if fstype, ok := resdef.Props[pluginFSTypeKey]; ok {
+		t.Errorf("expected LINSTOR not to format the volume, got file system '%s'", fstype)
+	}
+	if resdef.Props[pluginFSKey] != rawFS {
+		t.Errorf("expected the volume to be marked raw, got '%s'", resdef.Props[pluginFSKey])
+	}
+
+	resp, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"})
+	if err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	target := d.realMountPath("vol")
+	if resp.Mountpoint != target {
+		t.Errorf("expected the device at '%s', got '%s'", target, resp.Mountpoint)
+	}
+	if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected a file to bind mount the device on: %v", err)
+	}
+	expected := []string{"mkfile " + target, "mount " + testDevice + " " + target + "  bind"}
+	if !reflect.DeepEqual(d.mounter.calls, expected) {
+		t.Errorf("expected %v, got %v", expected, d.mounter.calls)
+	}
+	if len(d.exec.calls) != 0 || len(d.commands.calls) != 0 {
+		t.Errorf("expected no file system commands, got %v and %v", d.exec.calls, d.commands.calls)
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+	if d.mounter.mounted(target) {
+		t.Error("device is still bind mounted")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+	if calls := ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-a"); len(calls) != 1 {
+		t.Errorf("expected the diskless resource to be removed, got %v", calls)
+	}
+}
+
+func TestNVMeVolume(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"nvme": "true", "nodes": "node-b"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	resdef, _, _ := ctrl.resdef("vol")
+	var layers []client.LayerType
+	for _, layer := range resdef.LayerData {
+		layers = append(layers, layer.Type)
+	}
+	if expected := []client.LayerType{client.NVME, client.STORAGE}; !reflect.DeepEqual(layers, expected) {
+		t.Errorf("expected the layers %v, got %v", expected, layers)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	// the node attaches as NVMe-oF initiator, not as diskless DRBD client
+	ctrl.mu.Lock()
+	r, ok := ctrl.resdefs["vol"].resources["node-a"]
+	var flags []string
+	if ok {
+		flags = r.Flags
+	}
+	ctrl.mu.Unlock()
+	if !ok {
+		t.Fatal("expected a resource on node-a")
+	}
+	var initiator bool
+	for _, flag := range flags {
+		initiator = initiator || flag == flagNvmeInitiator
+	}
+	if !initiator {
+		t.Errorf("expected the resource on node-a to be an NVMe-oF initiator, got flags %v", flags)
+	}
+	if !d.mounter.mounted(d.realMountPath("vol")) {
+		t.Error("volume is not mounted")
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+	if calls := ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-a"); len(calls) != 1 {
+		t.Errorf("expected the initiator to be removed, got %v", calls)
+	}
+	if calls := ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-b"); len(calls) != 0 {
+		t.Errorf("expected the target to stay, got %v", calls)
+	}
+}
+
+func TestMountDisklessStoragePool(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl, "diskless-storage-pool = config-pool")
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"nodes": "node-b", "diskless-storage-pool": "volume-pool"}}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	resdef, _, _ := ctrl.resdef("vol")
+	if pool := resdef.Props[pluginOptionPrefix+"diskless-storage-pool"]; pool != "volume-pool" {
+		t.Errorf("expected the diskless storage pool to be persisted, got '%s'", pool)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	ctrl.mu.Lock()
+	r, ok := ctrl.resdefs["vol"].resources["node-a"]
+	var pool string
+	if ok {
+		pool = r.Props[linstor.KeyStorPoolName]
+	}
+	ctrl.mu.Unlock()
+	if !ok {
+		t.Fatal("expected a diskless resource on node-a")
+	}
+	if pool != "volume-pool" {
+		t.Errorf("expected the diskless resource in the volume's pool 'volume-pool', got '%s'", pool)
+	}
+}
+
+func TestMountDetectFS(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		blkid     string
+		blkidErr  error
+		defaultFS string
+		expected  string
+	}{
+		{"detected", "xfs\n", nil, "ext4", "xfs"},
+		{"default", "", errors.New("exit status 2"), "ext4", "ext4"},
+		{"none", "", errors.New("exit status 2"), "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newFakeLinstor(t, "node-a", "node-b")
+			ctrl.addVolume("vol", nil, "node-a", "node-b")
+			ctrl.setDevice("vol", "node-a", testDevice)
+			// created by an older version of the plugin
+			ctrl.mu.Lock()
+			delete(ctrl.resdefs["vol"].def.Props, pluginFSTypeKey)
+			ctrl.mu.Unlock()
+			var config []string
+			if tc.defaultFS != "" {
+				config = append(config, "default-fs = "+tc.defaultFS)
+			}
+			d := newTestDriver(t, ctrl, config...)
+			d.exec.outputs["blkid"] = tc.blkid
+			d.exec.errs["blkid"] = tc.blkidErr
+
+			_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"})
+			if tc.expected == "" {
+				if err == nil {
+					t.Fatal("expected the mount to fail without file system")
+				}
+				if d.mounter.mounted(d.realMountPath("vol")) {
+					t.Error("volume was mounted anyway")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mount failed: %v", err)
+			}
+			if len(d.exec.calls) == 0 || !strings.HasPrefix(d.exec.calls[0], "blkid ") {
+				t.Errorf("expected blkid to be asked first, got %v", d.exec.calls)
+			}
+			expected := "mount " + testDevice + " " + d.realMountPath("vol") + " " + tc.expected + " "
+			if len(d.mounter.calls) < 2 || !strings.HasPrefix(d.mounter.calls[1], expected) {
+				t.Errorf("expected '%s...', got %v", expected, d.mounter.calls)
+			}
+		})
+	}
+}
+
+// BenchmarkManagedDefinitions compares listing all resource definitions with the client-side filter for the plugin
+// flag on top, in a cluster where most definitions belong to something else
+func BenchmarkManagedDefinitions(b *testing.B) {
+	ctrl := newFakeLinstor(b, "node-a")
+	for i := 0; i < 1000; i++ {
+		var props map[string]string
+		if i%10 != 0 {
+			props = map[string]string{pluginFlagKey: ""}
+		}
+		ctrl.addVolume(fmt.Sprintf("vol%d", i), props)
+	}
+	d := newTestDriver(b, ctrl)
+	c, err := d.newClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	b.Run("all", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c.ResourceDefinitions.GetAll(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("managed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			managed, err := d.managedDefinitions(ctx, c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(managed) != 100 {
+				b.Fatalf("expected 100 managed definitions, got %d", len(managed))
+			}
+		}
+	})
+}
+
+func TestGetStatusSize(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a")
+	ctrl.addVolume("vol", nil, "node-a")
+	d := newTestDriver(t, ctrl)
+
+	resp, err := d.Get(&volume.GetRequest{Name: "vol"})
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if size := resp.Volume.Status["size-kib"]; size != uint64(100*kib) {
+		t.Errorf("expected the size of 100 MiB in the status, got %v", size)
+	}
+}
+
+func TestSnapshotStatus(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a")
+	ctrl.addVolume("vol", nil, "node-a")
+	d := newTestDriver(t, ctrl)
+	c, err := d.newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"auto-20260102-0300", "manual", "auto-20260101-0300"} {
+		if err := c.Resources.CreateSnapshot(ctx, client.Snapshot{Name: name, ResourceName: "vol"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []map[string]string{
+		{"name": "manual"},
+		{"name": "auto-20260101-0300", "created": "2026-01-01T03:00:00Z"},
+		{"name": "auto-20260102-0300", "created": "2026-01-02T03:00:00Z"},
+	}
+	if snapshots := d.snapshotStatus(ctx, c, "vol"); !reflect.DeepEqual(snapshots, expected) {
+		t.Errorf("expected the snapshots %v, got %v", expected, snapshots)
+	}
+}
+
+func TestAdoptNormalizesBooleans(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a")
+	ctrl.addVolume("vol", map[string]string{pluginFlagKey: ""}, "node-a")
+	d := newTestDriver(t, ctrl)
+
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"adopt": "true", "protect": "1", "keep-diskless": "yes"}})
+	if err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	resdef, _, _ := ctrl.resdef("vol")
+	if resdef.Props[pluginFlagKey] != pluginFlagValue {
+		t.Errorf("expected the volume to be managed by the plugin, got %v", resdef.Props)
+	}
+	for _, key := range []string{"protect", "keep-diskless"} {
+		if val := resdef.Props[pluginOptionPrefix+key]; val != "true" {
+			t.Errorf("expected %s to be stored as 'true', got '%s'", key, val)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// eventTimeout bounds the delivery of a single event, it never delays the operation itself
+const eventTimeout = 5 * time.Second
+
+// event is the JSON payload posted to LS_EVENT_WEBHOOK
+type event struct {
+	Action    string    `json:"action"`
+	Volume    string    `json:"volume"`
+	Node      string    `json:"node"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notify posts a lifecycle event of volume name if the operation succeeded, it is meant to be deferred with a
+// pointer to the named error result. Delivery happens in the background and failures are only logged.
+func (l *LinstorDriver) notify(action, name string, err *error) {
+	if *err != nil {
+		return
+	}
+	config, cerr := l.newConfig()
+	if cerr != nil || config.EventWebhook == "" {
+		return
+	}
+	body, jerr := json.Marshal(event{Action: action, Volume: name, Node: l.node, Timestamp: time.Now().UTC()})
+	if jerr != nil {
+		warnf("Could not encode %s event of volume '%s': %v", action, name, jerr)
+		return
+	}
+	go func() {
+		if err := postEvent(config.EventWebhook, body); err != nil {
+			warnf("Could not deliver %s event of volume '%s' to '%s': %v", action, name, config.EventWebhook, err)
+		}
+	}()
+}
+
+func postEvent(webhook string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// newWebhook records the events posted to it
+func newWebhook(t *testing.T, status int) (*httptest.Server, chan event) {
+	events := make(chan event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev event
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST, got %s with '%s'", r.Method, r.Header.Get("Content-Type"))
+		} else if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("could not decode event: %v", err)
+		}
+		w.WriteHeader(status)
+		events <- ev
+	}))
+	t.Cleanup(srv.Close)
+	return srv, events
+}
+
+func nextEvent(t *testing.T, events chan event) event {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(eventTimeout):
+		t.Fatal("no event was posted")
+	}
+	return event{}
+}
+
+func TestEvents(t *testing.T) {
+	webhook, events := newWebhook(t, http.StatusNoContent)
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	ctrl.setDevice("vol", "node-a", testDevice)
+	d := newTestDriver(t, ctrl, "event-webhook = "+webhook.URL)
+
+	start := time.Now().UTC()
+	for _, op := range []struct {
+		action string
+		run    func() error
+	}{
+		{"create", func() error { return d.Create(&volume.CreateRequest{Name: "vol"}) }},
+		{"mount", func() error {
+			_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"})
+			return err
+		}},
+		{"unmount", func() error { return d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c1"}) }},
+		{"remove", func() error { return d.Remove(&volume.RemoveRequest{Name: "vol"}) }},
+	} {
+		if err := op.run(); err != nil {
+			t.Fatalf("%s failed: %v", op.action, err)
+		}
+		ev := nextEvent(t, events)
+		if ev.Action != op.action || ev.Volume != "vol" || ev.Node != "node-a" {
+			t.Errorf("expected a %s event of vol on node-a, got %+v", op.action, ev)
+		}
+		if ev.Timestamp.Before(start.Add(-time.Second)) || ev.Timestamp.After(time.Now().Add(time.Second)) {
+			t.Errorf("expected a current timestamp, got %v", ev.Timestamp)
+		}
+	}
+
+	// failed operations are not announced
+	if _, err := d.Mount(&volume.MountRequest{Name: "missing", ID: "c1"}); err == nil {
+		t.Fatal("expected the mount of a missing volume to fail")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected no event for a failed operation, got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventDeliveryFailure(t *testing.T) {
+	webhook, events := newWebhook(t, http.StatusInternalServerError)
+	ctrl := newFakeLinstor(t, "node-a", "node-b")
+	d := newTestDriver(t, ctrl, "event-webhook = "+webhook.URL)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol"}); err != nil {
+		t.Fatalf("create failed although only the event could not be delivered: %v", err)
+	}
+	nextEvent(t, events)
+
+	// nobody listening at all
+	webhook.Close()
+	if err := d.Create(&volume.CreateRequest{Name: "other"}); err != nil {
+		t.Fatalf("create failed although only the event could not be delivered: %v", err)
+	}
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	linstor "github.com/LINBIT/golinstor"
+	"github.com/LINBIT/golinstor/client"
+	"k8s.io/kubernetes/pkg/util/mount"
+	mountutils "k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+)
+
+// fakeMounter keeps mounts in memory, directories and files are created for real so the driver can stat them
+type fakeMounter struct {
+	mount.Interface
+
+	mu     sync.Mutex
+	mounts map[string]string
+	// options of the last Mount
+	options []string
+	calls   []string
+	// errors of the next calls by method, MakeDir by path
+	mountErr   error
+	unmountErr error
+	makeDirErr map[string]error
+	opened     bool
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: make(map[string]string), makeDirErr: make(map[string]error)}
+}
+
+func (m *fakeMounter) record(format string, args ...interface{}) {
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *fakeMounter) Mount(source, target, fstype string, options []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("mount %s %s %s %s", source, target, fstype, strings.Join(options, ","))
+	if err := m.mountErr; err != nil {
+		m.mountErr = nil
+		return err
+	}
+	m.mounts[target] = source
+	m.options = options
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("unmount %s", target)
+	if err := m.unmountErr; err != nil {
+		m.unmountErr = nil
+		return err
+	}
+	if _, ok := m.mounts[target]; !ok {
+		return fmt.Errorf("%s is not mounted", target)
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsNotMountPoint(file string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[file]; ok {
+		return false, nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+func (m *fakeMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	return m.IsNotMountPoint(file)
+}
+
+func (m *fakeMounter) DeviceOpened(pathname string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.opened, nil
+}
+
+func (m *fakeMounter) PathIsDevice(pathname string) (bool, error) {
+	return true, nil
+}
+
+func (m *fakeMounter) MakeDir(pathname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("mkdir %s", pathname)
+	if err := m.makeDirErr[pathname]; err != nil {
+		delete(m.makeDirErr, pathname)
+		return err
+	}
+	return os.MkdirAll(pathname, 0755)
+}
+
+func (m *fakeMounter) MakeFile(pathname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("mkfile %s", pathname)
+	return ioutil.WriteFile(pathname, nil, 0644)
+}
+
+func (m *fakeMounter) ExistsPath(pathname string) (bool, error) {
+	_, err := os.Stat(pathname)
+	return err == nil, nil
+}
+
+func (m *fakeMounter) mounted(target string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.mounts[target]
+	return ok
+}
+
+// fakeExec answers commands of the mounter with canned output, keyed by the command name
+type fakeExec struct {
+	mu      sync.Mutex
+	outputs map[string]string
+	errs    map[string]error
+	calls   []string
+}
+
+func (e *fakeExec) Run(cmd string, args ...string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, strings.Join(append([]string{cmd}, args...), " "))
+	return []byte(e.outputs[cmd]), e.errs[cmd]
+}
+
+// fakeCommands records the commands the resizer runs, none of them does anything
+type fakeCommands struct {
+	exec.Interface
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (e *fakeCommands) Command(cmd string, args ...string) exec.Cmd {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, strings.Join(append([]string{cmd}, args...), " "))
+	return fakeCmd{}
+}
+
+func (e *fakeCommands) CommandContext(ctx context.Context, cmd string, args ...string) exec.Cmd {
+	return e.Command(cmd, args...)
+}
+
+type fakeCmd struct {
+	exec.Cmd
+}
+
+func (fakeCmd) Run() error                      { return nil }
+func (fakeCmd) CombinedOutput() ([]byte, error) { return nil, nil }
+func (fakeCmd) Output() ([]byte, error)         { return nil, nil }
+func (fakeCmd) SetStdin(in io.Reader)           {}
+func (fakeCmd) SetStdout(out io.Writer)         {}
+func (fakeCmd) SetStderr(out io.Writer)         {}
+
+type fakeResdef struct {
+	def       client.ResourceDefinition
+	transport string
+	vds       map[int32]client.VolumeDefinition
+	resources map[string]*client.ResourceWithVolumes
+	snapshots map[string]client.Snapshot
+}
+
+// fakeLinstor is an in-memory LINSTOR controller. Resources get a volume per volume definition, diskful DRBD ones are
+// UpToDate right away.
+type fakeLinstor struct {
+	t   testing.TB
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	nodes    []string
+	pools    []client.StoragePool
+	groups   map[string]client.ResourceGroup
+	resdefs  map[string]*fakeResdef
+	requests []string
+	failures map[string]*fakeFailure
+	// devices maps "<resource>/<node>/<volume>" to the device path reported for it
+	devices map[string]string
+	// diskState is reported for diskful DRBD volumes placed from now on, UpToDate if empty
+	diskState string
+}
+
+type fakeFailure struct {
+	status int
+	times  int
+}
+
+func newFakeLinstor(t testing.TB, nodes ...string) *fakeLinstor {
+	f := &fakeLinstor{
+		t:        t,
+		nodes:    nodes,
+		groups:   make(map[string]client.ResourceGroup),
+		resdefs:  make(map[string]*fakeResdef),
+		failures: make(map[string]*fakeFailure),
+		devices:  make(map[string]string),
+	}
+	for _, node := range nodes {
+		f.pools = append(f.pools, client.StoragePool{StoragePoolName: "pool", NodeName: node, ProviderKind: client.LVM_THIN, FreeCapacity: 1 << 30, TotalCapacity: 1 << 30})
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// fail makes the next times requests of method on path (without query) respond with status, times < 0 for all
+func (f *fakeLinstor) fail(method, path string, status, times int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method+" "+path] = &fakeFailure{status: status, times: times}
+}
+
+// calls returns the requests made so far that start with prefix, e.g. "POST /v1/resource-definitions"
+func (f *fakeLinstor) calls(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []string
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			calls = append(calls, r)
+		}
+	}
+	return calls
+}
+
+// objects lists all resource definitions, volume definitions, resources and snapshots still known
+func (f *fakeLinstor) objects() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var objects []string
+	for name, rd := range f.resdefs {
+		objects = append(objects, "resource-definition "+name)
+		for nr := range rd.vds {
+			objects = append(objects, fmt.Sprintf("volume-definition %s/%d", name, nr))
+		}
+		for node := range rd.resources {
+			objects = append(objects, fmt.Sprintf("resource %s/%s", name, node))
+		}
+		for snap := range rd.snapshots {
+			objects = append(objects, fmt.Sprintf("snapshot %s/%s", name, snap))
+		}
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// addVolume defines a volume of the plugin with the given props, diskful on nodes
+func (f *fakeLinstor) addVolume(name string, props map[string]string, nodes ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all := map[string]string{pluginFlagKey: pluginFlagValue, pluginFSTypeKey: "ext4"}
+	for key, val := range props {
+		all[key] = val
+	}
+	rd := &fakeResdef{
+		def:       client.ResourceDefinition{Name: name, Props: all},
+		vds:       map[int32]client.VolumeDefinition{0: {VolumeNumber: 0, SizeKib: 100 * kib}},
+		resources: make(map[string]*client.ResourceWithVolumes),
+		snapshots: make(map[string]client.Snapshot),
+	}
+	f.resdefs[name] = rd
+	for _, node := range nodes {
+		f.place(rd, client.Resource{Name: name, NodeName: node})
+	}
+}
+
+// testDevice stands in for the device of volumes, the driver only checks that it is a device it can open
+var testDevice = os.DevNull
+
+// resdef returns a copy of resource definition name and its transport type
+func (f *fakeLinstor) resdef(name string) (client.ResourceDefinition, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rd, ok := f.resdefs[name]
+	if !ok {
+		return client.ResourceDefinition{}, "", false
+	}
+	return rd.def, rd.transport, true
+}
+
+// setLayers sets the layer stack of resource definition name, its resources are placed again
+func (f *fakeLinstor) setLayers(name string, layers ...client.LayerType) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rd := f.resdefs[name]
+	rd.def.LayerData = toLayerData(layers)
+	for _, r := range rd.resources {
+		f.place(rd, r.Resource)
+	}
+}
+
+// setDiskState sets the disk state reported for diskful DRBD volumes placed from now on
+func (f *fakeLinstor) setDiskState(state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.diskState = state
+}
+
+// setDevice sets the device path reported for volume 0 of resource name on node
+func (f *fakeLinstor) setDevice(name, node, device string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devices[name+"/"+node+"/0"] = device
+	if rd, ok := f.resdefs[name]; ok {
+		if r, ok := rd.resources[node]; ok {
+			for i := range r.Volumes {
+				if r.Volumes[i].VolumeNumber == 0 {
+					r.Volumes[i].DevicePath = device
+				}
+			}
+		}
+	}
+}
+
+func (f *fakeLinstor) place(rd *fakeResdef, res client.Resource) {
+	diskless := false
+	for _, flag := range res.Flags {
+		diskless = diskless || flag == linstor.FlagDiskless
+	}
+	var layers []client.LayerType
+	for _, layer := range rd.def.LayerData {
+		layers = append(layers, layer.Type)
+	}
+	// only DRBD has disk states
+	state := "UpToDate"
+	if f.diskState != "" {
+		state = f.diskState
+	}
+	if isNVMeOnly(layers) {
+		state = ""
+	}
+	r := &client.ResourceWithVolumes{Resource: res}
+	var nrs []int
+	for nr := range rd.vds {
+		nrs = append(nrs, int(nr))
+	}
+	sort.Ints(nrs)
+	for _, nr := range nrs {
+		vol := client.Volume{
+			VolumeNumber: int32(nr),
+			StoragePool:  "pool",
+			ProviderKind: client.LVM_THIN,
+			DevicePath:   f.devices[fmt.Sprintf("%s/%s/%d", res.Name, res.NodeName, nr)],
+			State:        client.VolumeState{DiskState: state},
+		}
+		if diskless {
+			vol.StoragePool, vol.ProviderKind, vol.State.DiskState = "DfltDisklessStorPool", client.DISKLESS, "Diskless"
+		}
+		r.Volumes = append(r.Volumes, vol)
+	}
+	rd.resources[res.NodeName] = r
+}
+
+func (f *fakeLinstor) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if failure, ok := f.failures[r.Method+" "+r.URL.Path]; ok && failure.times != 0 {
+		failure.times--
+		apiError(w, failure.status, "injected failure")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	decode := func(v interface{}) bool {
+		if err := json.Unmarshal(body, v); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		return true
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + strings.Join(path, "/")
+	query := r.URL.Query()
+
+	switch {
+	case route == "GET v1/nodes":
+		// the controllers are probed with a limit of one node
+		var nodes []client.Node
+		for _, node := range f.nodes {
+			nodes = append(nodes, client.Node{Name: node, Type: "SATELLITE", ConnectionStatus: "ONLINE"})
+		}
+		if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit < len(nodes) {
+			nodes = nodes[:limit]
+		}
+		reply(w, nodes)
+	case route == "GET v1/view/storage-pools":
+		var pools []client.StoragePool
+		for _, pool := range f.pools {
+			if matches(query["nodes"], pool.NodeName) && matches(query["storage_pools"], pool.StoragePoolName) {
+				pools = append(pools, pool)
+			}
+		}
+		reply(w, pools)
+	case route == "GET v1/view/resources":
+		var resources []client.ResourceWithVolumes
+		for _, name := range f.resdefNames() {
+			for _, node := range f.resourceNodes(name) {
+				res := f.resdefs[name].resources[node]
+				if matches(query["resources"], name) && matches(query["nodes"], node) {
+					resources = append(resources, *res)
+				}
+			}
+		}
+		reply(w, resources)
+	case route == "GET v1/resource-definitions":
+		var defs []client.ResourceDefinition
+		for _, name := range f.resdefNames() {
+			defs = append(defs, f.resdefs[name].def)
+		}
+		reply(w, defs)
+	case route == "POST v1/resource-definitions":
+		var create client.ResourceDefinitionCreate
+		if !decode(&create) {
+			return
+		}
+		name := create.ResourceDefinition.Name
+		if _, ok := f.resdefs[name]; ok {
+			apiError(w, http.StatusConflict, "resource definition exists")
+			return
+		}
+		f.resdefs[name] = &fakeResdef{
+			def:       create.ResourceDefinition,
+			transport: create.DrbdTransportType,
+			vds:       make(map[int32]client.VolumeDefinition),
+			resources: make(map[string]*client.ResourceWithVolumes),
+			snapshots: make(map[string]client.Snapshot),
+		}
+		reply(w, nil)
+	case len(path) >= 3 && path[0] == "v1" && path[1] == "resource-definitions":
+		rd, ok := f.resdefs[path[2]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "resource definition not found")
+			return
+		}
+		f.serveResdef(w, r.Method, path[3:], rd, decode)
+	case len(path) >= 3 && path[0] == "v1" && path[1] == "resource-groups":
+		group, ok := f.groups[path[2]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "resource group not found")
+			return
+		}
+		if r.Method == http.MethodGet && len(path) == 3 {
+			reply(w, group)
+			return
+		}
+		var spawn client.ResourceGroupSpawn
+		if !decode(&spawn) {
+			return
+		}
+		rd := &fakeResdef{
+			def:       client.ResourceDefinition{Name: spawn.ResourceDefinitionName, ResourceGroupName: group.Name, Props: map[string]string{}},
+			vds:       make(map[int32]client.VolumeDefinition),
+			resources: make(map[string]*client.ResourceWithVolumes),
+			snapshots: make(map[string]client.Snapshot),
+		}
+		for i, size := range spawn.VolumeSizes {
+			rd.vds[int32(i)] = client.VolumeDefinition{VolumeNumber: int32(i), SizeKib: uint64(size)}
+		}
+		f.resdefs[rd.def.Name] = rd
+		f.autoplace(rd, group.SelectFilter)
+		reply(w, nil)
+	case route == "PATCH v1/encryption/passphrase" || route == "PUT v1/encryption/passphrase":
+		reply(w, nil)
+	default:
+		apiError(w, http.StatusNotFound, "no route for "+route)
+	}
+}
+
+func (f *fakeLinstor) serveResdef(w http.ResponseWriter, method string, path []string, rd *fakeResdef, decode func(interface{}) bool) {
+	name := rd.def.Name
+	switch {
+	case len(path) == 0 && method == http.MethodGet:
+		reply(w, rd.def)
+	case len(path) == 0 && method == http.MethodPut:
+		var modify client.GenericPropsModify
+		if !decode(&modify) {
+			return
+		}
+		if rd.def.Props == nil {
+			rd.def.Props = make(map[string]string)
+		}
+		for key, val := range modify.OverrideProps {
+			rd.def.Props[key] = val
+		}
+		for _, key := range modify.DeleteProps {
+			delete(rd.def.Props, key)
+		}
+		reply(w, nil)
+	case len(path) == 0 && method == http.MethodDelete:
+		// resources go with the resource definition, snapshots have to be deleted first
+		if len(rd.snapshots) > 0 {
+			apiError(w, http.StatusConflict, "resource definition still has snapshots")
+			return
+		}
+		delete(f.resdefs, name)
+		reply(w, nil)
+	case path[0] == "volume-definitions" && len(path) == 1 && method == http.MethodGet:
+		var vds []client.VolumeDefinition
+		for _, vd := range rd.vds {
+			vds = append(vds, vd)
+		}
+		reply(w, vds)
+	case path[0] == "volume-definitions" && len(path) == 1 && method == http.MethodPost:
+		var create client.VolumeDefinitionCreate
+		if !decode(&create) {
+			return
+		}
+		rd.vds[create.VolumeDefinition.VolumeNumber] = create.VolumeDefinition
+		reply(w, nil)
+	case path[0] == "volume-definitions" && len(path) == 2:
+		nr, _ := strconv.Atoi(path[1])
+		vd, ok := rd.vds[int32(nr)]
+		if !ok {
+			apiError(w, http.StatusNotFound, "volume definition not found")
+			return
+		}
+		switch method {
+		case http.MethodGet:
+			reply(w, vd)
+		case http.MethodPut:
+			var modify client.VolumeDefinitionModify
+			if !decode(&modify) {
+				return
+			}
+			if modify.SizeKib != 0 {
+				vd.SizeKib = modify.SizeKib
+			}
+			rd.vds[int32(nr)] = vd
+			reply(w, nil)
+		case http.MethodDelete:
+			delete(rd.vds, int32(nr))
+			reply(w, nil)
+		}
+	case path[0] == "autoplace" && method == http.MethodPost:
+		var req client.AutoPlaceRequest
+		if !decode(&req) {
+			return
+		}
+		f.autoplace(rd, req.SelectFilter)
+		reply(w, nil)
+	case path[0] == "resources" && len(path) == 1 && method == http.MethodGet:
+		var resources []client.Resource
+		for _, node := range f.resourceNodes(name) {
+			resources = append(resources, rd.resources[node].Resource)
+		}
+		reply(w, resources)
+	case path[0] == "resources" && len(path) == 2 && method == http.MethodPost:
+		var create client.ResourceCreate
+		if !decode(&create) {
+			return
+		}
+		if _, ok := rd.resources[path[1]]; ok {
+			apiError(w, http.StatusConflict, "resource exists")
+			return
+		}
+		f.place(rd, create.Resource)
+		reply(w, nil)
+	case path[0] == "resources" && len(path) >= 2:
+		res, ok := rd.resources[path[1]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "resource not found")
+			return
+		}
+		switch {
+		case len(path) == 2 && method == http.MethodGet:
+			reply(w, res.Resource)
+		case len(path) == 2 && method == http.MethodDelete:
+			delete(rd.resources, path[1])
+			reply(w, nil)
+		case len(path) == 4 && path[2] == "volumes" && method == http.MethodGet:
+			nr, _ := strconv.Atoi(path[3])
+			vol, ok := findVolume(res.Volumes, nr)
+			if !ok {
+				apiError(w, http.StatusNotFound, "volume not found")
+				return
+			}
+			reply(w, vol)
+		case len(path) >= 4 && path[2] == "toggle-disk" && method == http.MethodPut:
+			flags := res.Flags
+			if path[3] == "diskless" {
+				flags = []string{linstor.FlagDiskless}
+			} else {
+				flags = nil
+			}
+			f.place(rd, client.Resource{Name: name, NodeName: path[1], Props: res.Props, Flags: flags})
+			reply(w, nil)
+		default:
+			apiError(w, http.StatusNotFound, "no route")
+		}
+	case path[0] == "snapshots" && len(path) == 1 && method == http.MethodGet:
+		var snaps []client.Snapshot
+		for _, snap := range rd.snapshots {
+			snaps = append(snaps, snap)
+		}
+		reply(w, snaps)
+	case path[0] == "snapshots" && len(path) == 1 && method == http.MethodPost:
+		var snap client.Snapshot
+		if !decode(&snap) {
+			return
+		}
+		for _, nr := range sortedVolumes(rd) {
+			snap.VolumeDefinitions = append(snap.VolumeDefinitions, client.SnapshotVolumeDefinition{VolumeNumber: nr, SizeKib: rd.vds[nr].SizeKib})
+		}
+		if len(snap.Nodes) == 0 {
+			snap.Nodes = f.resourceNodes(name)
+		}
+		rd.snapshots[snap.Name] = snap
+		reply(w, nil)
+	case path[0] == "snapshots" && len(path) == 2:
+		snap, ok := rd.snapshots[path[1]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "snapshot not found")
+			return
+		}
+		if method == http.MethodDelete {
+			delete(rd.snapshots, path[1])
+			reply(w, nil)
+			return
+		}
+		reply(w, snap)
+	case (path[0] == "snapshot-restore-volume-definition" || path[0] == "snapshot-restore-resource") && len(path) == 2:
+		snap, ok := rd.snapshots[path[1]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "snapshot not found")
+			return
+		}
+		var restore client.SnapshotRestore
+		if !decode(&restore) {
+			return
+		}
+		target, ok := f.resdefs[restore.ToResource]
+		if !ok {
+			apiError(w, http.StatusNotFound, "target resource definition not found")
+			return
+		}
+		if path[0] == "snapshot-restore-volume-definition" {
+			for _, vd := range snap.VolumeDefinitions {
+				target.vds[vd.VolumeNumber] = client.VolumeDefinition{VolumeNumber: vd.VolumeNumber, SizeKib: vd.SizeKib}
+			}
+		} else {
+			nodes := restore.Nodes
+			if len(nodes) == 0 {
+				nodes = snap.Nodes
+			}
+			for _, node := range nodes {
+				f.place(target, client.Resource{Name: target.def.Name, NodeName: node})
+			}
+		}
+		reply(w, nil)
+	default:
+		apiError(w, http.StatusNotFound, "no route")
+	}
+}
+
+// autoplace puts diskful replicas on the first nodes without one, in the order the nodes were given
+func (f *fakeLinstor) autoplace(rd *fakeResdef, filter client.AutoSelectFilter) {
+	count := int(filter.PlaceCount)
+	if count == 0 {
+		count = 2
+	}
+	placed := 0
+	for _, res := range rd.resources {
+		if len(res.Flags) == 0 {
+			placed++
+		}
+	}
+	for _, node := range f.nodes {
+		if placed >= count {
+			break
+		}
+		if _, ok := rd.resources[node]; ok {
+			continue
+		}
+		f.place(rd, client.Resource{Name: rd.def.Name, NodeName: node})
+		placed++
+	}
+}
+
+func (f *fakeLinstor) resdefNames() []string {
+	var names []string
+	for name := range f.resdefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeLinstor) resourceNodes(name string) []string {
+	var nodes []string
+	for node := range f.resdefs[name].resources {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func sortedVolumes(rd *fakeResdef) []int32 {
+	var nrs []int32
+	for nr := range rd.vds {
+		nrs = append(nrs, nr)
+	}
+	sort.Slice(nrs, func(i, j int) bool { return nrs[i] < nrs[j] })
+	return nrs
+}
+
+// matches reports whether val is in filter, an empty filter matches everything
+func matches(filter []string, val string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if strings.EqualFold(f, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if v == nil {
+		v = []client.ApiCallRc{{RetCode: 0, Message: "ok"}}
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode([]client.ApiCallRc{{RetCode: -1, Message: msg}})
+}
+
+// testDriver is a driver on node "node-a" talking to ctrl, with fake mounter and resizer and its mount root in a
+// temporary directory. config is appended to the [global] section of its config file.
+type testDriver struct {
+	*LinstorDriver
+	mounter  *fakeMounter
+	exec     *fakeExec
+	commands *fakeCommands
+}
+
+func newTestDriver(t testing.TB, ctrl *fakeLinstor, config ...string) *testDriver {
+	dir, err := ioutil.TempDir("", "linstor-docker-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	root := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "[global]")
+	if ctrl != nil {
+		fmt.Fprintf(&buf, "controllers = %s\n", ctrl.srv.URL)
+	}
+	fmt.Fprintln(&buf, "maxretries = 1")
+	for _, line := range config {
+		fmt.Fprintln(&buf, line)
+	}
+	configFile := filepath.Join(dir, "linstor.cfg")
+	if err := ioutil.WriteFile(configFile, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &testDriver{
+		LinstorDriver: NewLinstorDriver(configFile, "", "node-a", root),
+		mounter:       newFakeMounter(),
+		exec:          &fakeExec{outputs: make(map[string]string), errs: make(map[string]error)},
+		commands:      &fakeCommands{},
+	}
+	d.LinstorDriver.mounter = &mount.SafeFormatAndMount{Interface: d.mounter, Exec: d.exec}
+	d.resizer = mountutils.NewResizeFs(d.commands)
+	return d
+}
+
+// mountedVolumes reads the gauge of mounted volumes
+func mountedVolumes() int {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	return metrics.mounted
+}
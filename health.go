@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Health checks that a controller is reachable, it only lists a single node so it is cheap to poll
+func (l *LinstorDriver) Health() (string, error) {
+	c, err := l.newClient()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	nodes, err := c.Nodes.GetAll(ctx, &client.ListOpts{PerPage: 1})
+	if err != nil {
+		return "", timeoutError("list nodes", err)
+	}
+	if len(nodes) == 0 {
+		return "LINSTOR controller is reachable, it has no nodes", nil
+	}
+	return fmt.Sprintf("LINSTOR controller is reachable, node '%s' is %s", nodes[0].Name, nodes[0].ConnectionStatus), nil
+}
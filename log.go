@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug":   levelDebug,
+	"info":    levelInfo,
+	"warn":    levelWarn,
+	"warning": levelWarn,
+	"error":   levelError,
+}
+
+var levelNames = map[logLevel]string{
+	levelDebug: "DEBUG",
+	levelInfo:  "INFO",
+	levelWarn:  "WARN",
+	levelError: "ERROR",
+}
+
+// the standard logger stays discarded, it is used by libraries we do not want to hear from
+var (
+	logger   = log.New(os.Stderr, "", log.LstdFlags)
+	minLevel = levelWarn
+)
+
+// setLogLevel sets the minimum level that gets logged, it is meant to be called once on startup
+func setLogLevel(name string) error {
+	if name == "" {
+		return nil
+	}
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("Unknown log level '%s'", name)
+	}
+	minLevel = level
+	return nil
+}
+
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	logger.Printf(levelNames[level]+" "+format, args...)
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
+
+// logError prefixes the error of a failed driver operation with the operation and the volume, so all errors Docker
+// shows look alike, and logs it. It is meant to be deferred with a pointer to the named error result.
+func logError(op, name string, err *error) {
+	if *err == nil {
+		return
+	}
+	if name == "" {
+		*err = fmt.Errorf("linstor: %s: %w", op, *err)
+	} else {
+		*err = fmt.Errorf("linstor: %s volume %q: %w", op, name, *err)
+	}
+	// Docker asks about volumes that belong to other drivers all the time
+	if errors.Is(*err, errNoSuchVolume) {
+		debugf("%v", *err)
+		return
+	}
+	errorf("%v", *err)
+}
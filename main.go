@@ -5,18 +5,26 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/docker/go-connections/sockets"
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vrischmann/envconfig"
 )
 
 const (
 	config = "/etc/linstor/docker-volume.conf"
 	plugin = "linstor"
+
+	defaultDrainTimeout = 30 * time.Second
 )
 
 var (
-	root = filepath.Join(volume.DefaultDockerRootDirectory, plugin)
+	defaultRoot   = filepath.Join(volume.DefaultDockerRootDirectory, plugin)
+	defaultSocket = filepath.Join("/run/docker/plugins", plugin+".sock")
 )
 
 func init() {
@@ -24,13 +32,122 @@ func init() {
 }
 
 func main() {
+	if err := setLogLevel(os.Getenv("LS_LOG_LEVEL")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
 	node, err := os.Hostname()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 
-	driver := NewLinstorDriver(config, node, root)
-	handler := volume.NewHandler(driver)
-	fmt.Println(handler.ServeUnix(plugin, 0))
+	var env struct {
+		MountRoot    string
+		ConfigDir    string
+		Socket       string
+		DrainTimeout time.Duration
+	}
+	if err := envconfig.InitWithOptions(&env, envconfig.Options{Prefix: "LS", AllOptional: true}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	root := defaultRoot
+	if env.MountRoot != "" {
+		root = env.MountRoot
+	}
+
+	driver := NewLinstorDriver(config, env.ConfigDir, node, root)
+	if len(os.Args) > 1 && os.Args[1] == "health" {
+		status, err := driver.Health()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(status)
+		return
+	}
+
+	if err := checkRoot(root); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		if err := driver.SelfTest(os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		fmt.Println("Self test passed")
+		return
+	}
+	if err := driver.LoadMounts(); err != nil {
+		warnf("Could not load saved mounts: %v", err)
+	}
+	if err := driver.Reconcile(); err != nil {
+		warnf("Could not clean up '%s': %v", root, err)
+	}
+	if err := driver.StartSnapshotSchedules(); err != nil {
+		warnf("Could not start snapshot schedules: %v", err)
+	}
+	if err := driver.StartMigrations(); err != nil {
+		warnf("Could not resume migrations: %v", err)
+	}
+
+	if addr := os.Getenv("LS_METRICS_ADDR"); addr != "" {
+		go func() {
+			errorf("Serving metrics on '%s' failed: %v", addr, driver.ServeMetrics(addr))
+		}()
+	}
+
+	listener, err := activatedListener()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if listener != nil {
+		infof("Serving on the socket passed by systemd")
+	} else {
+		socket := defaultSocket
+		if env.Socket != "" {
+			socket = env.Socket
+		}
+		if listener, err = sockets.NewUnixSocket(socket, 0); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// only a socket the plugin created itself is removed, systemd takes care of its own
+		defer os.Remove(socket)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- volume.NewHandler(driver).Serve(listener)
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-served:
+		fmt.Println(err)
+	case sig := <-signals:
+		drainTimeout := defaultDrainTimeout
+		if env.DrainTimeout > 0 {
+			drainTimeout = env.DrainTimeout
+		}
+		infof("Received %v, waiting up to %v for running operations", sig, drainTimeout)
+		listener.Close()
+		driver.Shutdown(drainTimeout)
+	}
+}
+
+// checkRoot makes sure volumes can be mounted below root, creating it if necessary
+func checkRoot(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("Could not create mount root '%s': %w", root, err)
+	}
+	probe, err := ioutil.TempFile(root, ".probe")
+	if err != nil {
+		return fmt.Errorf("Mount root '%s' is not writable: %w", root, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
 }
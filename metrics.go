@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the operation duration histogram
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// opMetrics counts driver operations and their durations, exposed in the Prometheus text format
+type opMetrics struct {
+	mu        sync.Mutex
+	results   map[string]map[string]uint64
+	durations map[string]*histogram
+	mounted   int
+}
+
+var metrics = &opMetrics{
+	results:   make(map[string]map[string]uint64),
+	durations: make(map[string]*histogram),
+}
+
+// observe records an operation started at start, meant to be deferred with a pointer to the named error result
+func (m *opMetrics) observe(op string, start time.Time, err *error) {
+	seconds := time.Since(start).Seconds()
+	result := "success"
+	if *err != nil {
+		result = "failure"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.results[op] == nil {
+		m.results[op] = make(map[string]uint64)
+	}
+	m.results[op][result]++
+
+	h, ok := m.durations[op]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		m.durations[op] = h
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *opMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ops := make([]string, 0, len(m.durations))
+	for op := range m.durations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintln(w, "# HELP linstor_docker_volume_operations_total Driver operations by result.")
+	fmt.Fprintln(w, "# TYPE linstor_docker_volume_operations_total counter")
+	for _, op := range ops {
+		for _, result := range []string{"success", "failure"} {
+			fmt.Fprintf(w, "linstor_docker_volume_operations_total{operation=%q,result=%q} %d\n", op, result, m.results[op][result])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP linstor_docker_volume_operation_duration_seconds Duration of driver operations.")
+	fmt.Fprintln(w, "# TYPE linstor_docker_volume_operation_duration_seconds histogram")
+	for _, op := range ops {
+		h := m.durations[op]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "linstor_docker_volume_operation_duration_seconds_bucket{operation=%q,le=\"%g\"} %d\n", op, bound, h.buckets[i])
+		}
+		fmt.Fprintf(w, "linstor_docker_volume_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(w, "linstor_docker_volume_operation_duration_seconds_sum{operation=%q} %g\n", op, h.sum)
+		fmt.Fprintf(w, "linstor_docker_volume_operation_duration_seconds_count{operation=%q} %d\n", op, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP linstor_docker_volume_mounted_volumes Volumes currently mounted on this node.")
+	fmt.Fprintln(w, "# TYPE linstor_docker_volume_mounted_volumes gauge")
+	fmt.Fprintf(w, "linstor_docker_volume_mounted_volumes %d\n", m.mounted)
+}
+
+// addMounted tracks the number of volumes mounted on this node
+func (m *opMetrics) addMounted(delta int) {
+	m.mu.Lock()
+	m.mounted += delta
+	m.mu.Unlock()
+}
+
+// ServeMetrics exposes the metrics on addr under /metrics
+func (l *LinstorDriver) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	})
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const (
+	migrationPollInterval = 5 * time.Second
+	migrationTimeout      = 24 * time.Hour
+	// a pending removal of old replicas is kept in the props of the volume until it is done
+	migrationSourcesKey = pluginOptionPrefix + "migrate-sources"
+	migrationTargetsKey = pluginOptionPrefix + "migrate-targets"
+)
+
+// migrate moves the diskful replicas of an existing volume to params.MigrateTo. The new replicas are created right
+// away, the old ones are removed in the background once all new ones are UpToDate. migrate-to has to name at least as
+// many nodes as there are replicas, so the volume does not end up with fewer. The pending removal is persisted,
+// StartMigrations resumes it after a restart of the plugin.
+func (l *LinstorDriver) migrate(ctx context.Context, c *client.Client, req *volume.CreateRequest, params *LinstorParams, resdef client.ResourceDefinition) error {
+	if resdef.Props[pluginFlagKey] != pluginFlagValue {
+		return fmt.Errorf("Volume '%s' is not managed by this plugin", req.Name)
+	}
+	// replicas are created according to the stack of the volume, not the config
+	params.Layers = nil
+	for _, layer := range resdef.LayerData {
+		params.Layers = append(params.Layers, layer.Type)
+	}
+
+	if err := l.checkNodes(ctx, c, params.MigrateTo); err != nil {
+		return err
+	}
+	diskful, diskless, err := l.replicaNodes(ctx, c, req.Name, volumeNumber(resdef.Props))
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]bool)
+	for _, node := range params.MigrateTo {
+		targets[node] = true
+	}
+	if len(targets) < len(diskful) {
+		return fmt.Errorf("Volume '%s' has %d diskful replicas, 'migrate-to' has to name at least as many nodes", req.Name, len(diskful))
+	}
+	for _, node := range params.MigrateTo {
+		if diskful[node] {
+			continue
+		}
+		if diskless[node] {
+			debugf("Converting diskless resource of volume '%s' on node '%s' to diskful", req.Name, node)
+			err = c.Resources.Diskful(ctx, req.Name, node, params.StoragePool)
+		} else {
+			debugf("Creating resource of volume '%s' on node '%s'", req.Name, node)
+			err = c.Resources.Create(ctx, l.toDiskfullCreate(req.Name, node, params))
+		}
+		if err != nil {
+			return fmt.Errorf("Could not create replica of volume '%s' on node '%s': %w", req.Name, node, timeoutError("create resource", err))
+		}
+	}
+
+	var sources []string
+	for node := range diskful {
+		if !targets[node] {
+			sources = append(sources, node)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	err = c.ResourceDefinitions.Modify(ctx, req.Name, client.GenericPropsModify{OverrideProps: map[string]string{
+		migrationSourcesKey: strings.Join(sources, " "),
+		migrationTargetsKey: strings.Join(params.MigrateTo, " "),
+	}})
+	if err != nil {
+		return timeoutError("set resource definition props", err)
+	}
+	infof("Migrating volume '%s' from %s to %s, waiting for the new replicas to sync", req.Name, strings.Join(sources, ", "), strings.Join(params.MigrateTo, ", "))
+	go l.finishMigration(req.Name, volumeNumber(resdef.Props), params.MigrateTo, sources, params.DisklessStoragePool)
+	return nil
+}
+
+// replicaNodes returns the nodes that have a diskful and a diskless resource of the volume
+func (l *LinstorDriver) replicaNodes(ctx context.Context, c *client.Client, name string, volNr int) (map[string]bool, map[string]bool, error) {
+	var resources []client.ResourceWithVolumes
+	err := l.retry(ctx, c, true, func(c *client.Client) (err error) {
+		resources, err = c.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{name}})
+		return err
+	})
+	if err != nil {
+		return nil, nil, timeoutError("get resource view", err)
+	}
+	diskful, diskless := make(map[string]bool), make(map[string]bool)
+	for _, r := range resources {
+		vol, ok := findVolume(r.Volumes, volNr)
+		if !ok {
+			continue
+		}
+		if vol.ProviderKind == client.DISKLESS {
+			diskless[r.NodeName] = true
+		} else {
+			diskful[r.NodeName] = true
+		}
+	}
+	return diskful, diskless, nil
+}
+
+// finishMigration removes the source replicas once all targets are UpToDate. Sources that are in use keep
+// diskless access instead.
+func (l *LinstorDriver) finishMigration(name string, volNr int, targets, sources []string, disklessPool string) {
+	deadline := time.Now().Add(migrationTimeout)
+	for {
+		pending, inUse, err := l.migrationState(name, volNr, targets)
+		if err == client.NotFoundError {
+			warnf("Volume '%s' is gone, stopping its migration", name)
+			return
+		} else if err != nil {
+			warnf("Could not check migration of volume '%s': %v", name, err)
+		} else if len(pending) == 0 {
+			l.removeSources(name, sources, inUse, disklessPool)
+			l.clearMigration(name)
+			return
+		} else {
+			debugf("Migration of volume '%s' waits for %s", name, strings.Join(pending, ", "))
+		}
+		if time.Now().After(deadline) {
+			errorf("Migration of volume '%s' timed out, keeping the replicas on %s", name, strings.Join(sources, ", "))
+			l.clearMigration(name)
+			return
+		}
+		time.Sleep(migrationPollInterval)
+	}
+}
+
+// migrationState returns the targets that are not UpToDate yet and the nodes the volume is in use on
+func (l *LinstorDriver) migrationState(name string, volNr int, targets []string) ([]string, map[string]bool, error) {
+	c, err := l.newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	resources, err := c.Resources.GetResourceView(ctx, &client.ListOpts{Resource: []string{name}})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resources) == 0 {
+		return nil, nil, client.NotFoundError
+	}
+	upToDate := make(map[string]bool)
+	inUse := make(map[string]bool)
+	for _, r := range resources {
+		if vol, ok := findVolume(r.Volumes, volNr); ok && vol.State.DiskState == "UpToDate" {
+			upToDate[r.NodeName] = true
+		}
+		if r.State.InUse {
+			inUse[r.NodeName] = true
+		}
+	}
+	var pending []string
+	for _, node := range targets {
+		if !upToDate[node] {
+			pending = append(pending, node)
+		}
+	}
+	return pending, inUse, nil
+}
+
+func (l *LinstorDriver) removeSources(name string, sources []string, inUse map[string]bool, disklessPool string) {
+	c, err := l.newClient()
+	if err != nil {
+		errorf("Could not remove old replicas of volume '%s': %v", name, err)
+		return
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	for _, node := range sources {
+		if inUse[node] {
+			infof("Volume '%s' is in use on node '%s', keeping diskless access there", name, node)
+			err = c.Resources.Diskless(ctx, name, node, disklessPool)
+		} else {
+			infof("Removing old replica of volume '%s' from node '%s'", name, node)
+			err = c.Resources.Delete(ctx, name, node)
+		}
+		if err != nil {
+			errorf("Could not remove old replica of volume '%s' from node '%s': %v", name, node, timeoutError("remove resource", err))
+		}
+	}
+}
+
+// clearMigration removes the pending migration from the props of the volume
+func (l *LinstorDriver) clearMigration(name string) {
+	c, err := l.newClient()
+	if err != nil {
+		warnf("Could not clear the migration of volume '%s': %v", name, err)
+		return
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	err = c.ResourceDefinitions.Modify(ctx, name, client.GenericPropsModify{DeleteProps: []string{migrationSourcesKey, migrationTargetsKey}})
+	if err != nil {
+		warnf("Could not clear the migration of volume '%s': %v", name, timeoutError("set resource definition props", err))
+	}
+}
+
+// StartMigrations resumes the migrations that were still waiting for their new replicas when the plugin stopped, it
+// is meant to be called once on startup
+func (l *LinstorDriver) StartMigrations() error {
+	c, err := l.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	resourceDefs, err := l.managedDefinitions(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, resourceDef := range resourceDefs {
+		sources := strings.Fields(resourceDef.Props[migrationSourcesKey])
+		if len(sources) == 0 {
+			continue
+		}
+		targets := strings.Fields(resourceDef.Props[migrationTargetsKey])
+		params, err := l.newParams(resourceDef.Name, persistedOptions(resourceDef.Props))
+		if err != nil {
+			warnf("Could not resume migration of volume '%s': %v", resourceDef.Name, err)
+			continue
+		}
+		infof("Resuming migration of volume '%s' from %s to %s", resourceDef.Name, strings.Join(sources, ", "), strings.Join(targets, ", "))
+		go l.finishMigration(resourceDef.Name, volumeNumber(resourceDef.Props), targets, sources, params.DisklessStoragePool)
+	}
+	return nil
+}
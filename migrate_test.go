@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestMigrateRejectsFewerNodes(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b", "node-c")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"migrate-to": "node-c"}})
+	if err == nil || !strings.Contains(err.Error(), "at least as many nodes") {
+		t.Fatalf("expected migrating 2 replicas to 1 node to fail, got: %v", err)
+	}
+	// the same node twice is still one node
+	err = d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"migrate-to": "node-c,node-c"}})
+	if err == nil {
+		t.Fatal("expected migrating 2 replicas to the same node twice to fail")
+	}
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/resources"); len(calls) != 0 {
+		t.Errorf("expected no replicas to be created, got %v", calls)
+	}
+}
+
+func TestMigrateCreatesTargets(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b", "node-c", "node-d")
+	ctrl.addVolume("vol", nil, "node-a", "node-b")
+	d := newTestDriver(t, ctrl)
+
+	err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"migrate-to": "node-b,node-c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := ctrl.calls("POST /v1/resource-definitions/vol/resources"); len(calls) != 1 || calls[0] != "POST /v1/resource-definitions/vol/resources/node-c" {
+		t.Errorf("expected only a replica on node-c to be created, got %v", calls)
+	}
+	// the new replica is UpToDate right away, so the old one goes soon
+	for deadline := time.Now().Add(5 * time.Second); len(ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-a")) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the old replica on node-a was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMigrateResumes(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a", "node-b", "node-c")
+	// the plugin stopped after node-c was created, before node-a was removed
+	ctrl.addVolume("vol", map[string]string{migrationSourcesKey: "node-a", migrationTargetsKey: "node-b node-c"}, "node-a", "node-b", "node-c")
+	d := newTestDriver(t, ctrl)
+
+	if err := d.StartMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resdef, _, _ := ctrl.resdef("vol")
+		_, pending := resdef.Props[migrationSourcesKey]
+		if !pending && len(ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-a")) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the old replica on node-a to be removed and the migration to be cleared, got %v", resdef.Props)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := ctrl.calls("DELETE /v1/resource-definitions/vol/resources/node-"); len(calls) != 1 {
+		t.Errorf("expected only node-a to be removed, got %v", calls)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// mountsFile below the mount root keeps the active mounts across restarts of the plugin
+const mountsFile = ".mounts.json"
+
+type mountRecord struct {
+	Mounts int    `json:"mounts"`
+	Subdir string `json:"subdir"`
+}
+
+// mountRecords mirrors the mount counts of all volumes, the volume states themselves are locked independently
+type mountRecords struct {
+	mu      sync.Mutex
+	records map[string]mountRecord
+}
+
+// saveMounts records the mounts of volume name and writes all of them to disk, the caller holds the lock of state
+func (l *LinstorDriver) saveMounts(name string, state *volumeState) {
+	l.mounts.mu.Lock()
+	defer l.mounts.mu.Unlock()
+	if l.mounts.records == nil {
+		l.mounts.records = make(map[string]mountRecord)
+	}
+	if state.mounts > 0 {
+		l.mounts.records[name] = mountRecord{Mounts: state.mounts, Subdir: state.subdir}
+	} else {
+		delete(l.mounts.records, name)
+	}
+	if err := l.writeMounts(); err != nil {
+		warnf("Could not save mounts: %v", err)
+	}
+}
+
+// writeMounts replaces the file atomically, a crash leaves either the old or the new state
+func (l *LinstorDriver) writeMounts() error {
+	data, err := json.Marshal(l.mounts.records)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(l.root, mountsFile)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.root, mountsFile))
+}
+
+// LoadMounts restores the mount counts saved before a restart. Only volumes that are actually still mounted are
+// taken over, so a later Unmount does not detach a volume that is in use. It is meant to be called once on startup.
+func (l *LinstorDriver) LoadMounts() error {
+	data, err := ioutil.ReadFile(filepath.Join(l.root, mountsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var records map[string]mountRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+
+	l.mounts.mu.Lock()
+	defer l.mounts.mu.Unlock()
+	l.mounts.records = make(map[string]mountRecord)
+	for name, record := range records {
+		if mounted, err := l.mounted(name); err != nil || !mounted || record.Mounts <= 0 {
+			debugf("Dropping saved mounts of volume '%s', it is not mounted anymore", name)
+			continue
+		}
+		state := l.lockVolume(name)
+		state.mounts, state.subdir = record.Mounts, record.Subdir
+		l.unlockVolume(name, state)
+		l.mounts.records[name] = record
+		metrics.addMounted(1)
+		infof("Volume '%s' is still mounted, %d active mounts", name, record.Mounts)
+	}
+	return l.writeMounts()
+}
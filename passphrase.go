@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/LINBIT/golinstor/client"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// readPassphrase returns the inline passphrase or, if that is empty, the content of file
+func readPassphrase(inline, file string) (string, error) {
+	if inline != "" || file == "" {
+		return inline, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Could not read LUKS passphrase file: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// rotatePassphrase replaces the LUKS master passphrase of the controller with LS_LUKS_NEW_PASSPHRASE. LINSTOR only
+// re-encrypts the volume keys with it, the data is not touched, and if the controller refuses the change the old
+// passphrase stays valid. The passphrases are never logged.
+func (l *LinstorDriver) rotatePassphrase(ctx context.Context, c *client.Client, req *volume.CreateRequest, resdef client.ResourceDefinition) error {
+	if resdef.Props[pluginFlagKey] != pluginFlagValue {
+		return fmt.Errorf("Volume '%s' is not managed by this plugin", req.Name)
+	}
+	encrypted := false
+	for _, layer := range resdef.LayerData {
+		if layer.Type == client.LUKS {
+			encrypted = true
+		}
+	}
+	if !encrypted {
+		return fmt.Errorf("Volume '%s' is not encrypted, it has no passphrase to rotate", req.Name)
+	}
+
+	config, err := l.newConfig()
+	if err != nil {
+		return err
+	}
+	oldPassphrase, err := readPassphrase(config.LuksPassphrase, config.LuksPassphraseFile)
+	if err != nil {
+		return err
+	}
+	newPassphrase, err := readPassphrase(config.LuksNewPassphrase, config.LuksNewPassphraseFile)
+	if err != nil {
+		return err
+	}
+	if oldPassphrase == "" || newPassphrase == "" {
+		return errors.New("Rotating the passphrase requires LS_LUKS_PASSPHRASE and LS_LUKS_NEW_PASSPHRASE (or their _FILE variants) to be set")
+	}
+	if oldPassphrase == newPassphrase {
+		return errors.New("LS_LUKS_NEW_PASSPHRASE is the current passphrase, there is nothing to rotate")
+	}
+
+	err = l.retry(ctx, c, false, func(c *client.Client) error {
+		return c.Encryption.Modify(ctx, client.Passphrase{OldPassphrase: oldPassphrase, NewPassphrase: newPassphrase})
+	})
+	if err != nil {
+		return fmt.Errorf("Could not rotate the LUKS passphrase, the old one stays valid: %w", timeoutError("modify passphrase", err))
+	}
+	warnf("Rotated the LUKS master passphrase via volume '%s', LS_LUKS_PASSPHRASE has to be set to the new one on all nodes", req.Name)
+	return nil
+}
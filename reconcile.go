@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// Reconcile cleans up after an unclean shutdown: empty leftover mount directories get removed, mounted ones
+// without a LINSTOR resource on this node are only reported. It is meant to be called once on startup.
+func (l *LinstorDriver) Reconcile() error {
+	entries, err := ioutil.ReadDir(l.root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var mounted []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		target := filepath.Join(l.root, entry.Name())
+		notMounted, err := l.mounter.IsNotMountPoint(target)
+		if err != nil {
+			warnf("Could not check mount point '%s': %v", target, err)
+			continue
+		}
+		if !notMounted {
+			mounted = append(mounted, entry.Name())
+			continue
+		}
+		// only succeeds for empty directories, anything else is left for the admin
+		if err := os.Remove(target); err != nil {
+			warnf("Could not remove leftover directory '%s': %v", target, err)
+		} else {
+			infof("Removed leftover directory '%s'", target)
+		}
+	}
+	if len(mounted) == 0 {
+		return nil
+	}
+
+	c, err := l.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+	for _, name := range mounted {
+		err := l.retry(ctx, c, true, func(c *client.Client) error {
+			_, err := c.Resources.Get(ctx, name, l.node)
+			return err
+		})
+		if err == client.NotFoundError {
+			warnf("'%s' is mounted, but volume '%s' has no resource on node '%s'", l.realMountPath(name), name, l.node)
+		} else if err != nil {
+			warnf("Could not check resource of mounted volume '%s': %v", name, timeoutError("get resource", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+const (
+	defaultMaxRetries = 3
+	retryBaseDelay    = 500 * time.Millisecond
+)
+
+// unavailableError is returned by the transport when the controller (or a proxy in front of it) could not
+// handle the request right now
+type unavailableError struct {
+	status int
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("controller unavailable: %s", http.StatusText(e.status))
+}
+
+// unavailableTransport turns gateway/unavailable responses into errors, golinstor would otherwise try to
+// decode them as LINSTOR API errors
+type unavailableTransport struct {
+	http.RoundTripper
+}
+
+func (t unavailableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		resp.Body.Close()
+		return nil, &unavailableError{status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// isTransient reports whether err is worth another attempt. Requests that are not idempotent are only
+// retried if they can not have reached the controller. A gateway might have passed the request on before it
+// failed, only 503 Service Unavailable says it was not handled at all. golinstor returns LINSTOR's API errors as
+// plain errors, so only the transport failures below are considered transient.
+func isTransient(err error, idempotent bool) bool {
+	if err == client.NotFoundError || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		return idempotent || unavailable.status == http.StatusServiceUnavailable
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return idempotent || opErr.Op == "dial"
+	}
+	return idempotent && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// retry calls fn with c until it succeeds, fails permanently or LS_MAX_RETRIES is exhausted, backing off
+// exponentially. Each retry gets a newly dialed client, which fails over to another controller if c's is gone.
+func (l *LinstorDriver) retry(ctx context.Context, c *client.Client, idempotent bool, fn func(c *client.Client) error) error {
+	err := fn(c)
+	if err == nil || !isTransient(err, idempotent) {
+		return err
+	}
+
+	// only bother with the config if something went wrong
+	maxRetries := defaultMaxRetries
+	if config, cerr := l.newConfig(); cerr == nil {
+		maxRetries = config.MaxRetries
+	}
+	delay := retryBaseDelay
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		// the next client probes the controllers again, this one might be gone
+		l.clients.reset()
+		debugf("Retrying in %v (%d/%d) after transient error: %v", delay, attempt, maxRetries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		if c, err = l.newClient(); err == nil {
+			err = fn(c)
+		}
+		if err == nil || !isTransient(err, idempotent) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		err        error
+		idempotent bool
+		transient  bool
+	}{
+		{"not found", client.NotFoundError, true, false},
+		{"api error", errors.New("Message: 'failed'"), true, false},
+		{"canceled", context.Canceled, true, false},
+		{"deadline", fmt.Errorf("get: %w", context.DeadlineExceeded), true, false},
+		{"503", &unavailableError{status: http.StatusServiceUnavailable}, false, true},
+		{"502 idempotent", &unavailableError{status: http.StatusBadGateway}, true, true},
+		{"502", &unavailableError{status: http.StatusBadGateway}, false, false},
+		{"504 idempotent", &unavailableError{status: http.StatusGatewayTimeout}, true, true},
+		{"504", &unavailableError{status: http.StatusGatewayTimeout}, false, false},
+		{"dial", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, false, true},
+		{"read idempotent", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true, true},
+		{"read", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, false, false},
+		{"eof idempotent", &url.Error{Op: "Get", Err: io.EOF}, true, true},
+		{"eof", &url.Error{Op: "Post", Err: io.EOF}, false, false},
+	} {
+		if transient := isTransient(tc.err, tc.idempotent); transient != tc.transient {
+			t.Errorf("%s: expected transient %v, got %v", tc.name, tc.transient, transient)
+		}
+	}
+}
+
+// flakyTransport fails the first requests with err before they are sent
+type flakyTransport struct {
+	mu    sync.Mutex
+	fails int
+	err   error
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fails > 0 {
+		f.fails--
+		return nil, f.err
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRetryFlakyTransport(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a")
+	ctrl.addVolume("vol", nil, "node-a")
+	d := newTestDriver(t, ctrl)
+	u, err := url.Parse(ctrl.srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := func() *client.Client {
+		transport := &flakyTransport{fails: 1, err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+		c, err := client.NewClient(client.BaseURL(u), client.HTTPClient(&http.Client{Transport: unavailableTransport{transport}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	err = d.retry(ctx, flaky(), true, func(c *client.Client) error {
+		_, err := c.ResourceDefinitions.Get(ctx, "vol")
+		return err
+	})
+	if err != nil {
+		t.Errorf("expected the idempotent request to be retried, got: %v", err)
+	}
+
+	// the connection broke after the request might have been sent
+	err = d.retry(ctx, flaky(), false, func(c *client.Client) error {
+		return c.ResourceDefinitions.Modify(ctx, "vol", client.GenericPropsModify{OverrideProps: map[string]string{"Aux/a": "b"}})
+	})
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected the modification not to be retried, got: %v", err)
+	}
+}
+
+func TestRetryUnavailableController(t *testing.T) {
+	ctrl := newFakeLinstor(t, "node-a")
+	ctrl.addVolume("vol", nil, "node-a")
+	d := newTestDriver(t, ctrl)
+	c, err := d.newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	modify := func(c *client.Client) error {
+		return c.ResourceDefinitions.Modify(ctx, "vol", client.GenericPropsModify{OverrideProps: map[string]string{"Aux/a": "b"}})
+	}
+
+	for _, tc := range []struct {
+		status  int
+		retried bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadGateway, false},
+		{http.StatusGatewayTimeout, false},
+	} {
+		before := len(ctrl.calls("PUT /v1/resource-definitions/vol"))
+		ctrl.fail(http.MethodPut, "/v1/resource-definitions/vol", tc.status, 1)
+		err := d.retry(ctx, c, false, modify)
+		attempts := len(ctrl.calls("PUT /v1/resource-definitions/vol")) - before
+		if tc.retried && (err != nil || attempts != 2) {
+			t.Errorf("%d: expected the modification to succeed on the second attempt, got %d attempts: %v", tc.status, attempts, err)
+		}
+		if !tc.retried && (err == nil || attempts != 1) {
+			t.Errorf("%d: expected the modification to fail without retry, got %d attempts: %v", tc.status, attempts, err)
+		}
+	}
+
+	// reading is always safe to repeat
+	ctrl.fail(http.MethodGet, "/v1/resource-definitions/vol", http.StatusBadGateway, 1)
+	err = d.retry(ctx, c, true, func(c *client.Client) error {
+		_, err := c.ResourceDefinitions.Get(ctx, "vol")
+		return err
+	})
+	if err != nil {
+		t.Errorf("expected the read to be retried, got: %v", err)
+	}
+}
+
+func TestRetryRedials(t *testing.T) {
+	first, second := newFakeLinstor(t, "node-a"), newFakeLinstor(t, "node-a")
+	second.addVolume("vol", nil, "node-a")
+	d := newTestDriver(t, nil, fmt.Sprintf("controllers = %s,%s", first.srv.URL, second.srv.URL))
+
+	c, err := d.newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client went to the first controller, which is gone now
+	first.srv.Close()
+
+	ctx := context.Background()
+	err = d.retry(ctx, c, true, func(c *client.Client) error {
+		_, err := c.ResourceDefinitions.Get(ctx, "vol")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the retry to fail over to the second controller, got: %v", err)
+	}
+	if len(second.calls("GET /v1/resource-definitions/vol")) != 1 {
+		t.Errorf("expected the second controller to get the request, got %v", second.calls(""))
+	}
+}
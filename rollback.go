@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// rollback tracks the LINSTOR objects a Create made, so a failure removes exactly those again
+type rollback struct {
+	name  string
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	what string
+	undo func(ctx context.Context) error
+}
+
+// add registers how to undo a step that just succeeded
+func (r *rollback) add(what string, undo func(ctx context.Context) error) {
+	r.steps = append(r.steps, rollbackStep{what: what, undo: undo})
+}
+
+// run undoes all registered steps in reverse order if *err is set, it is meant to be deferred with a pointer to the
+// named error result
+func (r *rollback) run(l *LinstorDriver, err *error) {
+	if *err == nil || len(r.steps) == 0 {
+		return
+	}
+	// the context of the request might be what made it fail
+	ctx, cancel := l.newContext()
+	defer cancel()
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		infof("Rolling back %s of volume '%s'", step.what, r.name)
+		if uerr := step.undo(ctx); uerr != nil && uerr != client.NotFoundError {
+			warnf("Could not roll back %s of volume '%s': %v", step.what, r.name, uerr)
+		}
+	}
+}
+
+// deleteResources removes all resources of name, whichever nodes they ended up on
+func deleteResources(ctx context.Context, c *client.Client, name string) error {
+	resources, err := c.Resources.GetAll(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		if err := c.Resources.Delete(ctx, name, r.NodeName); err != nil && err != client.NotFoundError {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/golinstor/client"
+)
+
+// autoSnapshotPrefix marks snapshots taken by the scheduler, only these are pruned. The rest of the name is the time
+// they were taken in autoSnapshotTimeFormat (UTC).
+const (
+	autoSnapshotPrefix     = "auto-"
+	autoSnapshotTimeFormat = "20060102-1504"
+)
+
+// cronSchedule is a parsed snapshot-schedule, either the usual five cron fields or a fixed interval
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	every                         time.Duration
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule parses "<minute> <hour> <day of month> <month> <day of week>", one of the @daily style
+// descriptors or "@every <duration>"
+func parseSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse snapshot-schedule '%s': %w", spec, err)
+		}
+		if every < time.Minute {
+			return nil, fmt.Errorf("Could not parse snapshot-schedule '%s': interval has to be at least a minute", spec)
+		}
+		return &cronSchedule{every: every}, nil
+	}
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Could not parse snapshot-schedule '%s': expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse snapshot-schedule '%s': %w", spec, err)
+		}
+		*f.bits = bits
+	}
+	// 7 is Sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("Snapshot-schedule '%s' never fires", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", "<n>" or "<n>-<m>", each with an optional "/<step>"
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if p := strings.SplitN(part, "/", 2); len(p) == 2 {
+			var err error
+			if step, err = strconv.Atoi(p[1]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = p[0]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range '%s'", part)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t the schedule fires, zero if there is none within the next years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	// aligned, so every node comes up with the same times
+	if s.every > 0 {
+		return t.Truncate(s.every).Add(s.every)
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: if both day fields are restricted, either of them has to match
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// snapshotJobs are the running snapshot schedules by volume
+type snapshotJobs struct {
+	mu     sync.Mutex
+	cancel map[string]context.CancelFunc
+}
+
+// scheduleSnapshots (re)starts the snapshot schedule of a volume. Every node runs the schedules of the volumes it
+// knows about, snapshot names are derived from the scheduled time, so only one of them gets taken.
+func (l *LinstorDriver) scheduleSnapshots(name, spec string, keep int) {
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		warnf("Not scheduling snapshots of volume '%s': %v", name, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(l.ctx)
+
+	l.jobs.mu.Lock()
+	if l.jobs.cancel == nil {
+		l.jobs.cancel = make(map[string]context.CancelFunc)
+	}
+	if old, ok := l.jobs.cancel[name]; ok {
+		old()
+	}
+	l.jobs.cancel[name] = cancel
+	l.jobs.mu.Unlock()
+
+	debugf("Scheduling snapshots of volume '%s' at '%s', keeping %d", name, spec, keep)
+	go l.runSnapshotSchedule(ctx, name, schedule, keep)
+}
+
+// unscheduleSnapshots stops the snapshot schedule of a volume, if there is one
+func (l *LinstorDriver) unscheduleSnapshots(name string) {
+	l.jobs.mu.Lock()
+	defer l.jobs.mu.Unlock()
+	if cancel, ok := l.jobs.cancel[name]; ok {
+		cancel()
+		delete(l.jobs.cancel, name)
+	}
+}
+
+func (l *LinstorDriver) runSnapshotSchedule(ctx context.Context, name string, schedule *cronSchedule, keep int) {
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		err := l.scheduledSnapshot(name, next, keep)
+		if err == client.NotFoundError {
+			// removed on another node
+			debugf("Volume '%s' is gone, stopping its snapshot schedule", name)
+			l.unscheduleSnapshots(name)
+			return
+		} else if err != nil {
+			warnf("Scheduled snapshot of volume '%s' failed: %v", name, err)
+		}
+	}
+}
+
+// scheduledSnapshot takes the snapshot for time at and prunes all but the last keep scheduled snapshots
+func (l *LinstorDriver) scheduledSnapshot(name string, at time.Time, keep int) error {
+	c, err := l.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	if _, err := c.ResourceDefinitions.Get(ctx, name); err != nil {
+		return err
+	}
+	snapName := autoSnapshotPrefix + at.UTC().Format(autoSnapshotTimeFormat)
+	if err := c.Resources.CreateSnapshot(ctx, client.Snapshot{Name: snapName, ResourceName: name}); err != nil {
+		// another node was faster
+		if _, gerr := c.Resources.GetSnapshot(ctx, name, snapName); gerr != nil {
+			return timeoutError("create snapshot", err)
+		}
+	} else {
+		infof("Took scheduled snapshot '%s' of volume '%s'", snapName, name)
+	}
+	if keep <= 0 {
+		return nil
+	}
+
+	snaps, err := c.Resources.GetSnapshots(ctx, name)
+	if err != nil {
+		return timeoutError("list snapshots", err)
+	}
+	var scheduled []string
+	for _, snap := range snaps {
+		if strings.HasPrefix(snap.Name, autoSnapshotPrefix) {
+			scheduled = append(scheduled, snap.Name)
+		}
+	}
+	// the names sort by time
+	sort.Strings(scheduled)
+	for len(scheduled) > keep {
+		debugf("Pruning scheduled snapshot '%s' of volume '%s'", scheduled[0], name)
+		if err := c.Resources.DeleteSnapshot(ctx, name, scheduled[0]); err != nil && err != client.NotFoundError {
+			return timeoutError("delete snapshot", err)
+		}
+		scheduled = scheduled[1:]
+	}
+	return nil
+}
+
+// StartSnapshotSchedules starts the snapshot schedules of all managed volumes, it is meant to be called once on
+// startup
+func (l *LinstorDriver) StartSnapshotSchedules() error {
+	c, err := l.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := l.newContext()
+	defer cancel()
+
+	resourceDefs, err := l.managedDefinitions(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, resourceDef := range resourceDefs {
+		spec, ok := resourceDef.Props[pluginOptionPrefix+"snapshot-schedule"]
+		if !ok {
+			continue
+		}
+		keep, _ := strconv.Atoi(resourceDef.Props[pluginOptionPrefix+"snapshot-keep"])
+		l.scheduleSnapshots(resourceDef.Name, spec, keep)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// selfTestSize is enough for any supported file system
+const selfTestSize = "64MiB"
+
+// SelfTest runs a throwaway volume through the driver the way Docker would: create it with a replica on this node,
+// mount it, write and read back a file, unmount and remove it. Every step is reported to out, after a failure the
+// volume is cleaned up as far as possible.
+func (l *LinstorDriver) SelfTest(out io.Writer) (err error) {
+	name := fmt.Sprintf("selftest-%s-%d", l.node, time.Now().Unix())
+	const id = "selftest"
+	step := func(what string, fn func() error) error {
+		if err := fn(); err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", what, err)
+			return err
+		}
+		fmt.Fprintf(out, "ok   %s\n", what)
+		return nil
+	}
+
+	err = step("create volume '"+name+"'", func() error {
+		return l.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"size": selfTestSize, "nodes": l.node}})
+	})
+	if err != nil {
+		return err
+	}
+	created, mounted := true, false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if mounted {
+			if uerr := l.Unmount(&volume.UnmountRequest{Name: name, ID: id}); uerr != nil {
+				fmt.Fprintf(out, "Could not clean up mount of '%s': %v\n", name, uerr)
+			}
+		}
+		if created {
+			if rerr := l.Remove(&volume.RemoveRequest{Name: name}); rerr != nil {
+				fmt.Fprintf(out, "Could not clean up volume '%s', remove it by hand: %v\n", name, rerr)
+			}
+		}
+	}()
+
+	var mountpoint string
+	err = step("mount", func() error {
+		resp, err := l.Mount(&volume.MountRequest{Name: name, ID: id})
+		if err != nil {
+			return err
+		}
+		mounted, mountpoint = true, resp.Mountpoint
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = step("write and read back a file", func() error {
+		// volumes without file system are handed out as device
+		if info, err := os.Stat(mountpoint); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("'%s' is not a directory, the default file system has to be set for the self test", mountpoint)
+		}
+		path := filepath.Join(mountpoint, "selftest")
+		data := []byte(name + "\n")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return err
+		}
+		read, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(read, data) {
+			return errors.New("read back different content")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = step("unmount", func() error {
+		return l.Unmount(&volume.UnmountRequest{Name: name, ID: id})
+	})
+	if err != nil {
+		return err
+	}
+	mounted = false
+	return step("remove", func() error {
+		return l.Remove(&volume.RemoveRequest{Name: name})
+	})
+}
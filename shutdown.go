@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// shutdownAbortGrace is how long aborted operations get to return their errors before the plugin exits
+const shutdownAbortGrace = 5 * time.Second
+
+var errShuttingDown = errors.New("Plugin is shutting down")
+
+// begin registers an operation with the drain of Shutdown, the returned func has to be called once it is done
+func (l *LinstorDriver) begin() (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		return nil, errShuttingDown
+	}
+	l.ops.Add(1)
+	return l.ops.Done, nil
+}
+
+// Shutdown rejects new operations and waits up to timeout for the running ones. What is still running then is
+// aborted by canceling the context of its LINSTOR calls. Mounts are left alone, Docker takes care of them.
+func (l *LinstorDriver) Shutdown(timeout time.Duration) {
+	l.mu.Lock()
+	l.closing = true
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.ops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		l.stop()
+		return
+	case <-time.After(timeout):
+	}
+
+	warnf("Operations still running after %v, aborting them", timeout)
+	l.stop()
+	select {
+	case <-done:
+	case <-time.After(shutdownAbortGrace):
+		errorf("Operations did not return after being aborted, exiting anyway")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const kib = 1024
+
+// sizeUnits are the size suffixes in upper case. SI suffixes (KB, MB, ...) are decimal, IEC suffixes (KiB, MiB,
+// ...) and the single letters binary, the way the LINSTOR client reads them.
+var sizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KI":  1 << 10,
+	"KIB": 1 << 10,
+	"KB":  1e3,
+	"M":   1 << 20,
+	"MI":  1 << 20,
+	"MIB": 1 << 20,
+	"MB":  1e6,
+	"G":   1 << 30,
+	"GI":  1 << 30,
+	"GIB": 1 << 30,
+	"GB":  1e9,
+	"T":   1 << 40,
+	"TI":  1 << 40,
+	"TIB": 1 << 40,
+	"TB":  1e12,
+	"P":   1 << 50,
+	"PI":  1 << 50,
+	"PIB": 1 << 50,
+	"PB":  1e15,
+}
+
+// parseSizeKiB parses sizes like "10GiB", "1.5G" or "500MB" and returns them in KiB, rounded up
+func parseSizeKiB(size string) (uint64, error) {
+	size = strings.TrimSpace(size)
+	i := strings.IndexFunc(size, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(size)
+	}
+	value, err := strconv.ParseFloat(size[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number in size '%s'", size)
+	}
+	mult, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(size[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size '%s', use e.g. KiB, MiB, GiB (binary) or KB, MB, GB (decimal)", size)
+	}
+	return uint64(math.Ceil(value * mult / kib)), nil
+}
+
+// formatKiB formats a size in KiB with the largest binary unit that keeps it readable
+func formatKiB(size uint64) string {
+	for _, u := range []struct {
+		name string
+		kib  uint64
+	}{{"PiB", 1 << 40}, {"TiB", 1 << 30}, {"GiB", 1 << 20}, {"MiB", 1 << 10}} {
+		if size >= u.kib {
+			return strings.TrimSuffix(strconv.FormatFloat(float64(size)/float64(u.kib), 'f', 1, 64), ".0") + u.name
+		}
+	}
+	return strconv.FormatUint(size, 10) + "KiB"
+}
+
+// drbdMetadataKiB estimates the internal DRBD metadata of a device of size KiB with the given number of peer slots.
+// It follows the DRBD user's guide: 72 sectors, plus 8 sectors per peer for every started 128MiB of data.
+func drbdMetadataKiB(size uint64, peers int) uint64 {
+	chunks := (size + 128*kib - 1) / (128 * kib)
+	return chunks*4*uint64(peers) + 36
+}
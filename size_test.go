@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseSizeKiB(t *testing.T) {
+	for _, tc := range []struct {
+		size     string
+		expected uint64
+	}{
+		{"1G", 1048576},
+		{"1GB", 976563},
+		{"1GiB", 1048576},
+		{"1gi", 1048576},
+		{"1024M", 1048576},
+		{"1.5G", 1572864},
+		{"500MB", 488282},
+		{" 100MiB ", 102400},
+		{"1025", 2},
+		{"1", 1},
+	} {
+		got, err := parseSizeKiB(tc.size)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.size, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %d KiB, got %d", tc.size, tc.expected, got)
+		}
+	}
+
+	for _, size := range []string{"", "G", "1XB", "1.2.3G", "-1G"} {
+		if _, err := parseSizeKiB(size); err == nil {
+			t.Errorf("expected %q to be rejected", size)
+		}
+	}
+}
+
+func TestFormatKiB(t *testing.T) {
+	for _, tc := range []struct {
+		size     uint64
+		expected string
+	}{
+		{512, "512KiB"},
+		{1024, "1MiB"},
+		{1536, "1.5MiB"},
+		{1048576, "1GiB"},
+		{976563, "953.7MiB"},
+	} {
+		if got := formatKiB(tc.size); got != tc.expected {
+			t.Errorf("%d: expected '%s', got '%s'", tc.size, tc.expected, got)
+		}
+	}
+}
+
+func TestDrbdMetadataKiB(t *testing.T) {
+	for _, tc := range []struct {
+		size     uint64
+		peers    int
+		expected uint64
+	}{
+		// 72 sectors plus 8 sectors per peer and started 128MiB
+		{1, 1, 40},
+		{128 * kib, 1, 40},
+		{128*kib + 1, 1, 44},
+		{102400, 7, 64},
+		{1048576, 7, 260},
+		{1048576, 31, 1028},
+	} {
+		if got := drbdMetadataKiB(tc.size, tc.peers); got != tc.expected {
+			t.Errorf("%d KiB with %d peers: expected %d KiB, got %d", tc.size, tc.peers, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// tlsCache keeps the last good TLS config, it is rebuilt once one of its files changes
+type tlsCache struct {
+	mu     sync.Mutex
+	paths  string
+	stamp  string
+	config *tls.Config
+}
+
+// tlsConfig returns the client TLS config for the configured files. A rotation that can not be read yet keeps
+// the previous config alive instead of failing every operation.
+func (l *LinstorDriver) tlsConfig(config *LinstorConfig) (*tls.Config, error) {
+	files := []string{config.CertFile, config.KeyFile, config.CAFile}
+	pems := []string{config.CertPem, config.KeyPem, config.CAPem}
+	var stamps []string
+	// inline PEM takes precedence over the file of the same kind
+	for i, pem := range pems {
+		if pem != "" {
+			files[i] = ""
+			stamps = append(stamps, fmt.Sprintf("%x", sha256.Sum256([]byte(pem))))
+		}
+	}
+	paths := strings.Join(files, "|")
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return l.tls.fallback(paths, fmt.Errorf("Could not read TLS file '%s': %w", file, err))
+		}
+		stamps = append(stamps, info.ModTime().String())
+	}
+	stamp := strings.Join(stamps, "|")
+
+	l.tls.mu.Lock()
+	cached := l.tls.config
+	if l.tls.paths != paths || l.tls.stamp != stamp {
+		cached = nil
+	}
+	l.tls.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	if config.TLSInsecure {
+		warnf("TLS certificates of the controller are not verified, LS_TLS_INSECURE is set")
+	}
+	var tlsConfig *tls.Config
+	var err error
+	if config.CertPem != "" || config.KeyPem != "" || config.CAPem != "" {
+		tlsConfig, err = pemTLSConfig(files, pems, config.TLSInsecure)
+	} else {
+		if config.CertFile != "" || config.KeyFile != "" {
+			if err := checkKeyPair(config.CertFile, config.KeyFile); err != nil {
+				return l.tls.fallback(paths, err)
+			}
+		}
+		tlsConfig, err = tlsconfig.Client(tlsconfig.Options{
+			CertFile:           config.CertFile,
+			KeyFile:            config.KeyFile,
+			CAFile:             config.CAFile,
+			InsecureSkipVerify: config.TLSInsecure,
+			ExclusiveRootPools: true,
+		})
+	}
+	if err != nil {
+		return l.tls.fallback(paths, err)
+	}
+
+	l.tls.mu.Lock()
+	defer l.tls.mu.Unlock()
+	l.tls.paths, l.tls.stamp, l.tls.config = paths, stamp, tlsConfig
+	return tlsConfig, nil
+}
+
+// pemTLSConfig builds the client TLS config from certificate, key and CA, each given either inline or as a file.
+// Without CA the controller is verified against the system pool.
+func pemTLSConfig(files, pems []string, insecure bool) (*tls.Config, error) {
+	for i, file := range files {
+		if file == "" {
+			continue
+		}
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read TLS file '%s': %w", file, err)
+		}
+		pems[i] = string(pem)
+	}
+	certPEM, keyPEM, caPEM := pems[0], pems[1], pems[2]
+
+	tlsConfig := tlsconfig.ClientDefault()
+	tlsConfig.InsecureSkipVerify = insecure
+	if caPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, errors.New("Could not find a certificate in the TLS CA")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certPEM != "" || keyPEM != "" {
+		if certPEM == "" || keyPEM == "" {
+			return nil, errors.New("TLS needs both a certificate and a key, only one of them is set")
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("TLS certificate and key do not match: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// fallback returns the cached config for the same files if there is one, err otherwise
+func (t *tlsCache) fallback(paths string, err error) (*tls.Config, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.config == nil || t.paths != paths {
+		return nil, err
+	}
+	warnf("Keeping previous TLS config: %v", err)
+	return t.config, nil
+}
+
+// checkKeyPair reads certificate and key and makes sure they belong together
+func checkKeyPair(certFile, keyFile string) error {
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("TLS needs both a certificate and a key, got certificate '%s' and key '%s'", certFile, keyFile)
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return fmt.Errorf("Could not read TLS certificate: %w", err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("Could not read TLS key: %w", err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("Certificate '%s' and key '%s' do not match: %w", certFile, keyFile, err)
+	}
+	return nil
+}